@@ -0,0 +1,110 @@
+package supervisor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/user"
+	"strings"
+)
+
+// accountTool selects commands creating system users and groups
+type accountTool int
+
+const (
+	// shadowTools are useradd and groupadd of shadow-utils
+	shadowTools accountTool = iota
+	// busyboxTools are adduser and addgroup of BusyBox
+	busyboxTools
+	// noAccountTools cannot create users, e.g. on darwin
+	noAccountTools
+)
+
+// chuid returns User and Group as user[:group]
+func (c *ServiceConfig) chuid() string {
+	if c.Group == "" {
+		return c.User
+	}
+	return c.User + ":" + c.Group
+}
+
+// planAccount adds creation of missing Group and User to p when
+// CreateUser is set. SupplementaryGroups must exist, the user is
+// created as their member.
+func (c *ServiceConfig) planAccount(p *Plan, tool accountTool) error {
+	if !c.CreateUser || c.User == "" {
+		return nil
+	}
+	if c.Group != "" && !c.accountExists("/etc/group", c.Group) {
+		if err := c.canCreateAccount(tool, "group "+c.Group); err != nil {
+			return err
+		}
+		switch tool {
+		case shadowTools:
+			p.command(false, "groupadd", "--system", c.Group)
+		case busyboxTools:
+			p.command(false, "addgroup", "-S", c.Group)
+		}
+	}
+	if c.accountExists("/etc/passwd", c.User) {
+		return nil
+	}
+	if err := c.canCreateAccount(tool, "user "+c.User); err != nil {
+		return err
+	}
+	switch tool {
+	case shadowTools:
+		args := []string{"--system", "--no-create-home", "--shell", "/usr/sbin/nologin"}
+		if c.Group != "" {
+			args = append(args, "--gid", c.Group)
+		}
+		if len(c.SupplementaryGroups) > 0 {
+			args = append(args, "--groups", strings.Join(c.SupplementaryGroups, ","))
+		}
+		p.command(false, "useradd", append(args, c.User)...)
+	case busyboxTools:
+		args := []string{"-S", "-D", "-H", "-s", "/bin/false"}
+		if c.Group != "" {
+			args = append(args, "-G", c.Group)
+		}
+		p.command(false, "adduser", append(args, c.User)...)
+		for _, g := range c.SupplementaryGroups {
+			p.command(false, "addgroup", c.User, g)
+		}
+	}
+	return nil
+}
+
+// canCreateAccount rejects creation of account by tool
+func (c *ServiceConfig) canCreateAccount(tool accountTool, account string) error {
+	switch {
+	case tool == noAccountTools:
+		return fmt.Errorf("%w: creating %s", ErrNotSupported, account)
+	case c.offline():
+		return fmt.Errorf("%w: creating %s in %s", ErrNotSupported, account, c.RootDir)
+	}
+	return nil
+}
+
+// accountExists looks name up in db, /etc/passwd or /etc/group, of
+// RootDir or in the user database of the host
+func (c *ServiceConfig) accountExists(db, name string) bool {
+	if c.offline() {
+		content, err := ioutil.ReadFile(c.path(db))
+		if err != nil {
+			return false
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if strings.HasPrefix(line, name+":") {
+				return true
+			}
+		}
+		return false
+	}
+	var err error
+	if db == "/etc/group" {
+		_, err = user.LookupGroup(name)
+	} else {
+		_, err = user.Lookup(name)
+	}
+	return err == nil
+}
@@ -0,0 +1,136 @@
+package supervisor
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// EnvBackend is the environment variable which forces the backend when
+// ServiceConfig.Backend is empty
+const EnvBackend = "SUPERVISOR_BACKEND"
+
+// BackendFactory returns service of the backend described by cfg
+type BackendFactory func(cfg ServiceConfig) Service
+
+// BackendDetector reports whether the backend manages the host and why
+type BackendDetector func() (ok bool, reason string)
+
+type backend struct {
+	name     string
+	factory  BackendFactory
+	detector BackendDetector
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   []backend
+)
+
+// RegisterBackend registers init system backend. Backends are detected in
+// registration order, registering a known name replaces the backend in place.
+func RegisterBackend(name string, factory BackendFactory, detector BackendDetector) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	b := backend{name: name, factory: factory, detector: detector}
+	for i := range backends {
+		if backends[i].name == name {
+			backends[i] = b
+			return
+		}
+	}
+	backends = append(backends, b)
+}
+
+// Backends returns names of registered backends in detection order
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	names := make([]string, 0, len(backends))
+	for _, b := range backends {
+		names = append(names, b.name)
+	}
+	return names
+}
+
+// NewServiceFor returns service of the named backend regardless of the host
+func NewServiceFor(name string, cfg ServiceConfig) (Service, error) {
+	b, ok := lookupBackend(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, name)
+	}
+	return checkScope(b.factory(cfg.withDefaults()), name, cfg)
+}
+
+func lookupBackend(name string) (backend, bool) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	for _, b := range backends {
+		if b.name == name {
+			return b, true
+		}
+	}
+	return backend{}, false
+}
+
+// detectBackend returns the first backend accepting the host
+func detectBackend() (backend, bool) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	for _, b := range backends {
+		if b.detector == nil {
+			continue
+		}
+		if ok, _ := b.detector(); ok {
+			return b, true
+		}
+	}
+	return backend{}, false
+}
+
+// scopedBackend is implemented by backends supporting scopes other than
+// the system one
+type scopedBackend interface {
+	supportsScope(scope Scope) bool
+}
+
+// checkScope rejects the scope of cfg when s does not support it. User
+// services run as the user owning the scope, they take no User and Group
+// except User naming that user on offline installs.
+func checkScope(s Service, backend string, cfg ServiceConfig) (Service, error) {
+	if cfg.Scope == "" || cfg.Scope == ScopeSystem {
+		return s, nil
+	}
+	if sb, ok := s.(scopedBackend); !ok || !sb.supportsScope(cfg.Scope) {
+		return nil, fmt.Errorf("%w: %s scope of %s", ErrNotSupported, cfg.Scope, backend)
+	}
+	if cfg.Scope == ScopeUser {
+		if cfg.User != "" && !cfg.offline() {
+			return nil, fmt.Errorf("%w: user %s of %s scope service", ErrNotSupported, cfg.User, cfg.Scope)
+		}
+		if cfg.Group != "" || len(cfg.SupplementaryGroups) > 0 || cfg.CreateUser {
+			return nil, fmt.Errorf("%w: groups or account creation of %s scope service", ErrNotSupported, cfg.Scope)
+		}
+	}
+	return s, nil
+}
+
+// newService returns service of the forced or detected backend
+func newService(cfg ServiceConfig) (Service, error) {
+	name := cfg.Backend
+	if name == "" {
+		name = os.Getenv(EnvBackend)
+	}
+	if name != "" {
+		return NewServiceFor(name, cfg)
+	}
+	b, ok := detectBackend()
+	if !ok {
+		return nil, ErrOSNotSupported
+	}
+	return checkScope(b.factory(cfg.withDefaults()), b.name, cfg)
+}
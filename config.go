@@ -0,0 +1,141 @@
+package supervisor
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Scope selects whether the service is managed system wide or by the
+// service manager of the calling user
+type Scope string
+
+const (
+	ScopeSystem Scope = "system"
+	ScopeUser   Scope = "user"
+)
+
+// ServiceConfig describes supervised service
+type ServiceConfig struct {
+	// Backend forces the init system backend, e.g. systemd or procd,
+	// see RegisterBackend
+	Backend string
+	// Name of the service, used for unit and script file names
+	Name string
+	// Cmd is the command to supervise
+	Cmd string
+	// Args are appended to Cmd
+	Args         []string
+	Description  string
+	WorkingDir   string
+	LogFile      string
+	Dependencies []string
+	Environ      map[string]string
+	// User and Group the service runs as, root by default
+	User  string
+	Group string
+	// SupplementaryGroups of the service, set on the unit by systemd and
+	// through membership of created User by other backends
+	SupplementaryGroups []string
+	// CreateUser creates missing User and Group as system accounts on
+	// install
+	CreateUser bool
+	// Labels are recorded in the marker of generated files, e.g. project
+	// or app ID, see List
+	Labels map[string]string
+	// Limits are resource limits of the service, see Limits
+	Limits Limits
+	// Hardening sandboxes the service on systemd, see Hardening
+	Hardening Hardening
+	// RestartPolicy controls restarting of the exited service on every
+	// backend, see RestartPolicy
+	RestartPolicy RestartPolicy
+	// Restart is the systemd restart policy, "on-failure" by default.
+	// Mode of RestartPolicy overrides it.
+	Restart string
+	// RestartSec is the systemd restart delay in seconds, "10" by default.
+	// Delay of RestartPolicy overrides it.
+	RestartSec string
+	// StopSignal is sent to stop the service, SIGTERM by default
+	StopSignal syscall.Signal
+	// StopTimeout is the time the service has to exit after StopSignal
+	// before it is killed with SIGKILL, zero keeps the default of the
+	// backend
+	StopTimeout time.Duration
+	// KillMode selects whether StopSignal is sent to the main process or
+	// every process of the service, see KillMode
+	KillMode KillMode
+	// Hooks are commands run before and after start and stop and on
+	// reload, see Hooks
+	Hooks Hooks
+	// Executor runs init system commands, LocalExecutor by default
+	Executor Executor
+	// Escalate runs commands and file changes through sudo, doas or
+	// pkexec when the process lacks privileges, see Escalation
+	Escalate Escalation
+	// Scope is the system or user scope, empty selects the backend
+	// default, see Scope
+	Scope Scope
+	// Linger keeps user services running without a login session, it is
+	// enabled on install with loginctl enable-linger. Offline installs
+	// enable it for User.
+	Linger bool
+	// RootDir installs into a mounted root filesystem, e.g. an SD card
+	// image. Every path is put under RootDir, boot enablement is done
+	// with symlinks and init system commands are not run.
+	RootDir string
+}
+
+// withDefaults fills unset knobs with their default values
+func (c ServiceConfig) withDefaults() ServiceConfig {
+	if mode, err := c.RestartPolicy.mode(); err == nil && c.RestartPolicy.Mode != "" {
+		c.Restart = systemdRestart[mode]
+	}
+	if c.RestartPolicy.Delay > 0 {
+		c.RestartSec = strconv.Itoa(seconds(c.RestartPolicy.Delay))
+	}
+	if c.Restart == "" {
+		c.Restart = "on-failure"
+	}
+	if c.RestartSec == "" {
+		c.RestartSec = "10"
+	}
+	return c
+}
+
+// systemdOnly rejects settings only systemd supports on other backends
+func (c *ServiceConfig) systemdOnly(backend string) error {
+	switch {
+	case c.Limits.CPUQuota != 0:
+		return fmt.Errorf("%w: CPUQuota on %s", ErrNotSupported, backend)
+	case c.Hardening.enabled():
+		return fmt.Errorf("%w: hardening on %s", ErrNotSupported, backend)
+	}
+	return nil
+}
+
+// args returns configured arguments followed by extra ones
+func (c *ServiceConfig) args(extra []string) []string {
+	args := make([]string, 0, len(c.Args)+len(extra))
+	args = append(args, c.Args...)
+	return append(args, extra...)
+}
+
+// offline reports whether services are installed into RootDir
+func (c *ServiceConfig) offline() bool {
+	return c.RootDir != ""
+}
+
+// path returns p under RootDir
+func (c *ServiceConfig) path(p string) string {
+	return rootPath(c.RootDir, p)
+}
+
+func rootPath(root, p string) string {
+	if root == "" {
+		return p
+	}
+	return filepath.Join(root, p)
+}
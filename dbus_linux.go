@@ -0,0 +1,666 @@
+package supervisor
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dbusSystemBus is the system bus address unless DBUS_SYSTEM_BUS_ADDRESS is set
+const dbusSystemBus = "unix:path=/var/run/dbus/system_bus_socket"
+
+// message types and header fields of the D-Bus wire protocol
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusErrorReply   = 3
+	dbusSignal       = 4
+
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSender      = 7
+	dbusFieldSignature   = 8
+)
+
+// dbusMaxMessage is the maximum message size allowed by the specification
+const dbusMaxMessage = 128 << 20
+
+// dbusConn is a minimal D-Bus client, enough to call methods of systemd
+// and wait for its signals
+type dbusConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	serial  uint32
+	signals []*dbusMessage
+}
+
+// dbusMessage is a received message with decoded header fields and body
+type dbusMessage struct {
+	typ    byte
+	serial uint32
+	fields map[byte]interface{}
+	body   []interface{}
+}
+
+// dbusVariant is a value of the D-Bus variant type
+type dbusVariant struct {
+	sig   string
+	value interface{}
+}
+
+// dbusError is an error reply of a method call
+type dbusError struct {
+	Name    string
+	Message string
+}
+
+func (e *dbusError) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Message
+}
+
+// Is maps well known error names to sentinel errors
+func (e *dbusError) Is(target error) bool {
+	switch target {
+	case ErrNotInstalled:
+		return e.Name == "org.freedesktop.systemd1.NoSuchUnit"
+	case ErrPermissionDenied:
+		return e.Name == "org.freedesktop.DBus.Error.AccessDenied" ||
+			e.Name == "org.freedesktop.DBus.Error.InteractiveAuthorizationRequired"
+	}
+	return false
+}
+
+// dialSystemBus connects to the system bus
+func dialSystemBus(ctx context.Context) (*dbusConn, error) {
+	address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	if address == "" {
+		address = dbusSystemBus
+	}
+	return dialBus(ctx, address)
+}
+
+// dialSessionBus connects to the bus of the calling user, which the user
+// instance of systemd is on
+func dialSessionBus(ctx context.Context) (*dbusConn, error) {
+	address := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	if address == "" {
+		runtime := os.Getenv("XDG_RUNTIME_DIR")
+		if runtime == "" {
+			runtime = "/run/user/" + strconv.Itoa(os.Getuid())
+		}
+		address = "unix:path=" + runtime + "/bus"
+	}
+	return dialBus(ctx, address)
+}
+
+// dialBus connects and authenticates to the first reachable unix socket
+// of address
+func dialBus(ctx context.Context, address string) (*dbusConn, error) {
+	err := fmt.Errorf("dbus: no supported transport in %q", address)
+	for _, a := range strings.Split(address, ";") {
+		socket, ok := busSocket(a)
+		if !ok {
+			continue
+		}
+		var d net.Dialer
+		conn, dialErr := d.DialContext(ctx, "unix", socket)
+		if dialErr != nil {
+			err = dialErr
+			continue
+		}
+		bus := &dbusConn{conn: conn, r: bufio.NewReader(conn)}
+		if err = bus.auth(ctx); err == nil {
+			_, err = bus.call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello", "")
+		}
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		return bus, nil
+	}
+	return nil, err
+}
+
+// busSocket returns socket path of a unix:path= or unix:abstract= address
+func busSocket(address string) (string, bool) {
+	if !strings.HasPrefix(address, "unix:") {
+		return "", false
+	}
+	for _, kv := range strings.Split(strings.TrimPrefix(address, "unix:"), ",") {
+		switch {
+		case strings.HasPrefix(kv, "path="):
+			return strings.TrimPrefix(kv, "path="), true
+		case strings.HasPrefix(kv, "abstract="):
+			return "@" + strings.TrimPrefix(kv, "abstract="), true
+		}
+	}
+	return "", false
+}
+
+func (b *dbusConn) Close() error {
+	return b.conn.Close()
+}
+
+// auth performs EXTERNAL authentication with the uid of the process
+func (b *dbusConn) auth(ctx context.Context) error {
+	defer b.watch(ctx)()
+
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := io.WriteString(b.conn, "\x00AUTH EXTERNAL "+uid+"\r\n"); err != nil {
+		return b.connError(ctx, "auth", err)
+	}
+	line, err := b.r.ReadString('\n')
+	if err != nil {
+		return b.connError(ctx, "auth", err)
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("dbus: authentication rejected: %s", strings.TrimSpace(line))
+	}
+	if _, err := io.WriteString(b.conn, "BEGIN\r\n"); err != nil {
+		return b.connError(ctx, "auth", err)
+	}
+	return nil
+}
+
+// watch interrupts blocked reads and writes once ctx is done, the returned
+// function stops watching. It waits for the watcher so ctx cancelled
+// afterwards cannot interrupt later calls on the connection.
+func (b *dbusConn) watch(ctx context.Context) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			b.conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// connError converts error of interrupted connection into context error
+func (b *dbusConn) connError(ctx context.Context, member string, err error) error {
+	if ctxErr := contextError(ctx, "dbus "+member); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// call calls method and returns body of the reply, signals received in
+// the meantime are queued for waitSignal
+func (b *dbusConn) call(ctx context.Context, dest, path, iface, member, sig string, args ...interface{}) ([]interface{}, error) {
+	defer b.watch(ctx)()
+
+	serial, err := b.send(dest, path, iface, member, sig, args)
+	if err != nil {
+		return nil, b.connError(ctx, member, err)
+	}
+	for {
+		msg, err := b.receive()
+		if err != nil {
+			return nil, b.connError(ctx, member, err)
+		}
+		if msg.typ == dbusSignal {
+			b.signals = append(b.signals, msg)
+			continue
+		}
+		if reply, _ := msg.fields[dbusFieldReplySerial].(uint32); reply != serial {
+			continue
+		}
+		if msg.typ == dbusErrorReply {
+			e := &dbusError{}
+			e.Name, _ = msg.fields[dbusFieldErrorName].(string)
+			if len(msg.body) > 0 {
+				e.Message, _ = msg.body[0].(string)
+			}
+			return nil, e
+		}
+		return msg.body, nil
+	}
+}
+
+// waitSignal returns body of the first signal accepted by match
+func (b *dbusConn) waitSignal(ctx context.Context, iface, member string, match func(body []interface{}) bool) ([]interface{}, error) {
+	accept := func(msg *dbusMessage) bool {
+		return msg.fields[dbusFieldInterface] == iface && msg.fields[dbusFieldMember] == member && match(msg.body)
+	}
+	for i, msg := range b.signals {
+		if accept(msg) {
+			b.signals = append(b.signals[:i], b.signals[i+1:]...)
+			return msg.body, nil
+		}
+	}
+
+	defer b.watch(ctx)()
+	for {
+		msg, err := b.receive()
+		if err != nil {
+			return nil, b.connError(ctx, member, err)
+		}
+		if msg.typ == dbusSignal && accept(msg) {
+			return msg.body, nil
+		}
+	}
+}
+
+// send writes method call and returns its serial
+func (b *dbusConn) send(dest, path, iface, member, sig string, args []interface{}) (uint32, error) {
+	return b.write(dbusMethodCall, []interface{}{
+		[]interface{}{byte(dbusFieldPath), dbusVariant{"o", path}},
+		[]interface{}{byte(dbusFieldInterface), dbusVariant{"s", iface}},
+		[]interface{}{byte(dbusFieldMember), dbusVariant{"s", member}},
+		[]interface{}{byte(dbusFieldDestination), dbusVariant{"s", dest}},
+	}, sig, args)
+}
+
+// write writes message of type typ with header fields, given as a(yv), and
+// body args of signature sig, it returns serial of the message
+func (b *dbusConn) write(typ byte, fields []interface{}, sig string, args []interface{}) (uint32, error) {
+	body := &dbusEncoder{}
+	sigs, err := splitSignature(sig)
+	if err != nil {
+		return 0, err
+	}
+	if len(sigs) != len(args) {
+		return 0, fmt.Errorf("dbus: %d arguments for signature %q", len(args), sig)
+	}
+	for i, s := range sigs {
+		if err := body.encode(s, args[i]); err != nil {
+			return 0, err
+		}
+	}
+
+	b.serial++
+	if sig != "" {
+		fields = append(fields, []interface{}{byte(dbusFieldSignature), dbusVariant{"g", sig}})
+	}
+	msg := &dbusEncoder{buf: []byte{'l', typ, 0, 1}}
+	msg.uint32(uint32(len(body.buf)))
+	msg.uint32(b.serial)
+	if err := msg.encode("a(yv)", fields); err != nil {
+		return 0, err
+	}
+	msg.align(8)
+	_, err = b.conn.Write(append(msg.buf, body.buf...))
+	return b.serial, err
+}
+
+// receive reads the next message
+func (b *dbusConn) receive() (*dbusMessage, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(b.r, fixed); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("dbus: invalid byte order %q", fixed[0])
+	}
+	bodyLen := int(order.Uint32(fixed[4:]))
+	headerLen := 16 + int(order.Uint32(fixed[12:]))
+	headerLen += (8 - headerLen%8) % 8
+	if bodyLen < 0 || headerLen+bodyLen > dbusMaxMessage {
+		return nil, errors.New("dbus: message too long")
+	}
+	buf := make([]byte, headerLen+bodyLen)
+	copy(buf, fixed)
+	if _, err := io.ReadFull(b.r, buf[16:]); err != nil {
+		return nil, err
+	}
+
+	msg := &dbusMessage{typ: fixed[1], serial: order.Uint32(fixed[8:]), fields: make(map[byte]interface{})}
+	header := &dbusDecoder{buf: buf[:headerLen], pos: 12, order: order}
+	fields, err := header.decode("a(yv)")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields.([]interface{}) {
+		if kv := f.([]interface{}); len(kv) == 2 {
+			msg.fields[kv[0].(byte)] = kv[1]
+		}
+	}
+	sig, _ := msg.fields[dbusFieldSignature].(string)
+	sigs, err := splitSignature(sig)
+	if err != nil {
+		return nil, err
+	}
+	body := &dbusDecoder{buf: buf[headerLen:], order: order}
+	for _, s := range sigs {
+		v, err := body.decode(s)
+		if err != nil {
+			return nil, err
+		}
+		msg.body = append(msg.body, v)
+	}
+	return msg, nil
+}
+
+// dbusAlignment returns alignment of type code c
+func dbusAlignment(c byte) int {
+	switch c {
+	case 'n', 'q':
+		return 2
+	case 'b', 'i', 'u', 'h', 's', 'o', 'a':
+		return 4
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 1
+}
+
+// splitSignature splits sig into single complete types
+func splitSignature(sig string) ([]string, error) {
+	var sigs []string
+	for sig != "" {
+		n := completeType(sig)
+		if n == 0 {
+			return nil, fmt.Errorf("dbus: invalid signature %q", sig)
+		}
+		sigs = append(sigs, sig[:n])
+		sig = sig[n:]
+	}
+	return sigs, nil
+}
+
+// completeType returns length of the first complete type of sig, 0 if
+// sig is invalid
+func completeType(sig string) int {
+	if sig == "" {
+		return 0
+	}
+	switch sig[0] {
+	case 'a':
+		if n := completeType(sig[1:]); n > 0 {
+			return n + 1
+		}
+		return 0
+	case '(', '{':
+		depth := 0
+		for i := 0; i < len(sig); i++ {
+			switch sig[i] {
+			case '(', '{':
+				depth++
+			case ')', '}':
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return 0
+	case ')', '}':
+		return 0
+	}
+	return 1
+}
+
+// dbusEncoder marshals values in little endian, offsets are relative to
+// the start of buf which must be 8 byte aligned in the message
+type dbusEncoder struct {
+	buf []byte
+}
+
+func (e *dbusEncoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *dbusEncoder) uint32(v uint32) {
+	e.align(4)
+	e.buf = append(e.buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// encode marshals v as single complete type sig. Arrays are given as
+// []string or []interface{}, structs as []interface{}.
+func (e *dbusEncoder) encode(sig string, v interface{}) error {
+	switch sig[0] {
+	case 'y':
+		if b, ok := v.(byte); ok {
+			e.buf = append(e.buf, b)
+			return nil
+		}
+	case 'b':
+		if b, ok := v.(bool); ok {
+			var u uint32
+			if b {
+				u = 1
+			}
+			e.uint32(u)
+			return nil
+		}
+	case 'i':
+		if i, ok := v.(int32); ok {
+			e.uint32(uint32(i))
+			return nil
+		}
+	case 'u':
+		if u, ok := v.(uint32); ok {
+			e.uint32(u)
+			return nil
+		}
+	case 's', 'o':
+		if s, ok := v.(string); ok {
+			e.uint32(uint32(len(s)))
+			e.buf = append(append(e.buf, s...), 0)
+			return nil
+		}
+	case 'g':
+		if s, ok := v.(string); ok && len(s) < 256 {
+			e.buf = append(append(append(e.buf, byte(len(s))), s...), 0)
+			return nil
+		}
+	case 'v':
+		if variant, ok := v.(dbusVariant); ok {
+			if err := e.encode("g", variant.sig); err != nil {
+				return err
+			}
+			return e.encode(variant.sig, variant.value)
+		}
+	case 'a':
+		var items []interface{}
+		switch a := v.(type) {
+		case []string:
+			for _, s := range a {
+				items = append(items, s)
+			}
+		case []interface{}:
+			items = a
+		default:
+			return fmt.Errorf("dbus: cannot encode %T as %s", v, sig)
+		}
+		e.uint32(0)
+		at := len(e.buf) - 4
+		e.align(dbusAlignment(sig[1]))
+		start := len(e.buf)
+		for _, item := range items {
+			if err := e.encode(sig[1:], item); err != nil {
+				return err
+			}
+		}
+		binary.LittleEndian.PutUint32(e.buf[at:], uint32(len(e.buf)-start))
+		return nil
+	case '(', '{':
+		fields, ok := v.([]interface{})
+		sigs, err := splitSignature(sig[1 : len(sig)-1])
+		if !ok || err != nil || len(fields) != len(sigs) {
+			break
+		}
+		e.align(8)
+		for i, s := range sigs {
+			if err := e.encode(s, fields[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("dbus: cannot encode %T as %s", v, sig)
+}
+
+// dbusDecoder unmarshals values from buf
+type dbusDecoder struct {
+	buf   []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+func (d *dbusDecoder) read(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.buf) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *dbusDecoder) align(n int) error {
+	pad := (n - d.pos%n) % n
+	_, err := d.read(pad)
+	return err
+}
+
+func (d *dbusDecoder) fixed(sig byte) (interface{}, error) {
+	n := dbusAlignment(sig)
+	if err := d.align(n); err != nil {
+		return nil, err
+	}
+	b, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+	switch sig {
+	case 'y':
+		return b[0], nil
+	case 'n':
+		return int16(d.order.Uint16(b)), nil
+	case 'q':
+		return d.order.Uint16(b), nil
+	case 'b':
+		return d.order.Uint32(b) != 0, nil
+	case 'i':
+		return int32(d.order.Uint32(b)), nil
+	case 'x':
+		return int64(d.order.Uint64(b)), nil
+	case 't':
+		return d.order.Uint64(b), nil
+	case 'd':
+		return math.Float64frombits(d.order.Uint64(b)), nil
+	}
+	return d.order.Uint32(b), nil
+}
+
+// decode unmarshals single complete type sig. Arrays are returned as
+// []interface{}, dicts as map[string]interface{} and structs as
+// []interface{}.
+func (d *dbusDecoder) decode(sig string) (interface{}, error) {
+	switch sig[0] {
+	case 'y', 'n', 'q', 'b', 'i', 'u', 'h', 'x', 't', 'd':
+		return d.fixed(sig[0])
+	case 's', 'o':
+		n, err := d.fixed('u')
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.read(int(n.(uint32)) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return string(b[:len(b)-1]), nil
+	case 'g':
+		n, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.read(int(n[0]) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return string(b[:len(b)-1]), nil
+	case 'v':
+		s, err := d.decode("g")
+		if err != nil {
+			return nil, err
+		}
+		sigs, err := splitSignature(s.(string))
+		if err != nil || len(sigs) != 1 {
+			return nil, fmt.Errorf("dbus: invalid variant signature %q", s)
+		}
+		return d.decode(sigs[0])
+	case 'a':
+		n, err := d.fixed('u')
+		if err != nil {
+			return nil, err
+		}
+		if err := d.align(dbusAlignment(sig[1])); err != nil {
+			return nil, err
+		}
+		end := d.pos + int(n.(uint32))
+		if end > len(d.buf) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if sig[1] == '{' {
+			dict := make(map[string]interface{})
+			for d.pos < end {
+				entry, err := d.decode(sig[1:])
+				if err != nil {
+					return nil, err
+				}
+				kv := entry.([]interface{})
+				dict[fmt.Sprint(kv[0])] = kv[1]
+			}
+			return dict, nil
+		}
+		var items []interface{}
+		for d.pos < end {
+			item, err := d.decode(sig[1:])
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case '(', '{':
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		sigs, err := splitSignature(sig[1 : len(sig)-1])
+		if err != nil {
+			return nil, err
+		}
+		var fields []interface{}
+		for _, s := range sigs {
+			v, err := d.decode(s)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, v)
+		}
+		return fields, nil
+	}
+	return nil, fmt.Errorf("dbus: unsupported signature %q", sig)
+}
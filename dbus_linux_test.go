@@ -0,0 +1,281 @@
+package supervisor
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDBusCodec(t *testing.T) {
+	tests := []struct {
+		sig  string
+		in   interface{}
+		want interface{}
+	}{
+		{"y", byte(7), byte(7)},
+		{"b", true, true},
+		{"i", int32(-2), int32(-2)},
+		{"u", uint32(42), uint32(42)},
+		{"s", "isaax-agent.service", "isaax-agent.service"},
+		{"o", "/org/freedesktop/systemd1", "/org/freedesktop/systemd1"},
+		{"g", "a{sv}", "a{sv}"},
+		{"v", dbusVariant{"u", uint32(1)}, uint32(1)},
+		{"as", []string{"a.service", "b.service"}, []interface{}{"a.service", "b.service"}},
+		{"as", []string{}, []interface{}(nil)},
+		{"(sb)", []interface{}{"unit", false}, []interface{}{"unit", false}},
+		{"a(yv)", []interface{}{
+			[]interface{}{byte(1), dbusVariant{"o", "/"}},
+			[]interface{}{byte(3), dbusVariant{"s", "Hello"}},
+		}, []interface{}{
+			[]interface{}{byte(1), "/"},
+			[]interface{}{byte(3), "Hello"},
+		}},
+		{"a{sv}", []interface{}{
+			[]interface{}{"MainPID", dbusVariant{"u", uint32(1234)}},
+			[]interface{}{"Environment", dbusVariant{"as", []string{"A=1"}}},
+		}, map[string]interface{}{
+			"MainPID":     uint32(1234),
+			"Environment": []interface{}{"A=1"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sig, func(t *testing.T) {
+			// a leading byte checks alignment of the value
+			e := &dbusEncoder{buf: []byte{0}}
+			if err := e.encode(tt.sig, tt.in); err != nil {
+				t.Fatalf("encode(%q, %v) failed: %v", tt.sig, tt.in, err)
+			}
+			d := &dbusDecoder{buf: e.buf, pos: 1, order: binary.LittleEndian}
+			got, err := d.decode(tt.sig)
+			if err != nil {
+				t.Fatalf("decode(%q) failed: %v", tt.sig, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decode(%q) = %#v, want %#v", tt.sig, got, tt.want)
+			}
+			if d.pos != len(e.buf) {
+				t.Errorf("decode(%q) read %d of %d bytes", tt.sig, d.pos, len(e.buf))
+			}
+		})
+	}
+}
+
+func TestDBusEncodeMismatch(t *testing.T) {
+	tests := []struct {
+		sig string
+		in  interface{}
+	}{
+		{"u", "1"},
+		{"s", 1},
+		{"as", "a"},
+		{"(su)", []interface{}{"a"}},
+		{"v", uint32(1)},
+	}
+	for _, tt := range tests {
+		if err := (&dbusEncoder{}).encode(tt.sig, tt.in); err == nil {
+			t.Errorf("encode(%q, %#v) succeeded", tt.sig, tt.in)
+		}
+	}
+}
+
+func TestDBusDecodeTruncated(t *testing.T) {
+	e := &dbusEncoder{}
+	if err := e.encode("as", []string{"a.service"}); err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(e.buf); n++ {
+		d := &dbusDecoder{buf: e.buf[:n], order: binary.LittleEndian}
+		if _, err := d.decode("as"); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("decode of %d bytes returned %v, want %v", n, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestSplitSignature(t *testing.T) {
+	tests := []struct {
+		sig  string
+		want []string
+		ok   bool
+	}{
+		{"", nil, true},
+		{"ss", []string{"s", "s"}, true},
+		{"sa{sv}as", []string{"s", "a{sv}", "as"}, true},
+		{"a(sa(yv))u", []string{"a(sa(yv))", "u"}, true},
+		{"a", nil, false},
+		{"(s", nil, false},
+		{"s)", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := splitSignature(tt.sig)
+		if (err == nil) != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitSignature(%q) = %q, %v, want %q", tt.sig, got, err, tt.want)
+		}
+	}
+}
+
+func TestBusSocket(t *testing.T) {
+	tests := []struct {
+		address string
+		socket  string
+		ok      bool
+	}{
+		{"unix:path=/var/run/dbus/system_bus_socket", "/var/run/dbus/system_bus_socket", true},
+		{"unix:abstract=/tmp/dbus-XYZ,guid=1234", "@/tmp/dbus-XYZ", true},
+		{"unix:guid=1234,path=/run/user/1000/bus", "/run/user/1000/bus", true},
+		{"tcp:host=localhost,port=4000", "", false},
+		{"unix:tmpdir=/tmp", "", false},
+	}
+	for _, tt := range tests {
+		socket, ok := busSocket(tt.address)
+		if socket != tt.socket || ok != tt.ok {
+			t.Errorf("busSocket(%q) = %q, %v, want %q, %v", tt.address, socket, ok, tt.socket, tt.ok)
+		}
+	}
+}
+
+// startBus starts a private dbus-daemon and returns its address, the test
+// is skipped without dbus-daemon
+func startBus(t *testing.T) string {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	dir, err := ioutil.TempDir("", "dbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	config := filepath.Join(dir, "bus.conf")
+	err = ioutil.WriteFile(config, []byte(`<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=`+filepath.Join(dir, "bus")+`</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading address of dbus-daemon: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+// dialTestBus connects to address, the connection is closed by cleanup
+func dialTestBus(t *testing.T, address string) *dbusConn {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bus, err := dialBus(ctx, address)
+	if err != nil {
+		t.Fatalf("dialBus(%q) failed: %v", address, err)
+	}
+	t.Cleanup(func() { bus.Close() })
+	return bus
+}
+
+func TestDBusConn(t *testing.T) {
+	address := startBus(t)
+	bus := dialTestBus(t, address)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := bus.call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "GetNameOwner", "s", "org.freedesktop.DBus")
+	if err != nil || len(out) != 1 || out[0] != "org.freedesktop.DBus" {
+		t.Errorf("GetNameOwner() = %v, %v", out, err)
+	}
+
+	_, err = bus.call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "GetNameOwner", "s", "org.freedesktop.systemd1")
+	var reply *dbusError
+	if !errors.As(err, &reply) || reply.Name != "org.freedesktop.DBus.Error.NameHasNoOwner" || reply.Message == "" {
+		t.Errorf("GetNameOwner() of missing name returned %v", err)
+	}
+
+	if _, err := bus.call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "GetNameOwner", "u", "x"); err == nil {
+		t.Error("call with argument not matching signature succeeded")
+	}
+}
+
+func TestDBusSignal(t *testing.T) {
+	address := startBus(t)
+	bus := dialTestBus(t, address)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rule := "type='signal',interface='org.freedesktop.DBus',member='NameOwnerChanged'"
+	if _, err := bus.call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "AddMatch", "s", rule); err != nil {
+		t.Fatalf("AddMatch() failed: %v", err)
+	}
+	if _, err := bus.call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "RequestName", "su", "io.isaax.Test", uint32(0)); err != nil {
+		t.Fatalf("RequestName() failed: %v", err)
+	}
+	// NameOwnerChanged(s name, s old, s new) was queued by call
+	body, err := bus.waitSignal(ctx, "org.freedesktop.DBus", "NameOwnerChanged", func(body []interface{}) bool {
+		return len(body) == 3 && body[0] == "io.isaax.Test"
+	})
+	if err != nil || body[1] != "" || body[2] == "" {
+		t.Errorf("waitSignal() = %v, %v", body, err)
+	}
+
+	// a second connection owning a name is signalled while waiting
+	other := dialTestBus(t, address)
+	go other.call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "RequestName", "su", "io.isaax.Other", uint32(0))
+	if _, err := bus.waitSignal(ctx, "org.freedesktop.DBus", "NameOwnerChanged", func(body []interface{}) bool {
+		return len(body) == 3 && body[0] == "io.isaax.Other"
+	}); err != nil {
+		t.Errorf("waitSignal() failed: %v", err)
+	}
+
+	short, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = bus.waitSignal(short, "org.freedesktop.DBus", "NameOwnerChanged", func([]interface{}) bool { return false })
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) {
+		t.Errorf("waitSignal() past deadline returned %v, want TimeoutError", err)
+	}
+}
+
+func TestDialBus(t *testing.T) {
+	ctx := context.Background()
+	if _, err := dialBus(ctx, "tcp:host=localhost,port=1"); err == nil {
+		t.Error("dialBus() of tcp address succeeded")
+	}
+	if _, err := dialBus(ctx, "unix:path=/nonexistent/bus"); err == nil {
+		t.Error("dialBus() of missing socket succeeded")
+	}
+	address := startBus(t)
+	bus := dialTestBus(t, "unix:path=/nonexistent/bus;"+address)
+	if bus.serial == 0 {
+		t.Error("dialBus() did not say Hello")
+	}
+}
@@ -0,0 +1,72 @@
+package supervisor
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+)
+
+// InitSystem is the init system report of the host
+type InitSystem struct {
+	// Backend selected for the host
+	Backend string
+	// Forced reports whether Backend was set through SUPERVISOR_BACKEND
+	Forced bool
+	// Version of the init system or distribution, e.g. "systemd 245"
+	// or "OpenWrt 21.02.1", empty if unknown
+	Version string
+	// Container reports whether the host runs in a container
+	Container bool
+	// UserManager reports whether the user systemd instance is reachable
+	UserManager bool
+	// Checks are detector results of every registered backend
+	Checks []BackendCheck
+}
+
+// BackendCheck is the detector result of a backend
+type BackendCheck struct {
+	Backend  string
+	Accepted bool
+	Reason   string
+}
+
+// detectTimeout bounds commands run while detecting the init system
+const detectTimeout = 5 * time.Second
+
+// DetectInitSystem reports the init system of the host and why each
+// registered backend was accepted or rejected
+func DetectInitSystem() InitSystem {
+	var report InitSystem
+
+	backendsMu.RLock()
+	for _, b := range backends {
+		check := BackendCheck{Backend: b.name}
+		if b.detector != nil {
+			check.Accepted, check.Reason = b.detector()
+		}
+		if check.Accepted && report.Backend == "" {
+			report.Backend = b.name
+		}
+		report.Checks = append(report.Checks, check)
+	}
+	backendsMu.RUnlock()
+
+	if name := os.Getenv(EnvBackend); name != "" {
+		report.Backend, report.Forced = name, true
+	}
+
+	report.Version = initVersion(report.Backend)
+	report.Container = inContainer()
+	report.UserManager = userManagerReachable()
+	return report
+}
+
+// detectOutput runs command for detection and returns trimmed stdout
+func detectOutput(command string, arguments ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), detectTimeout)
+	defer cancel()
+
+	stdout, _, err := LocalExecutor{}.Execute(ctx, Command{Path: command, Args: arguments})
+	return strings.TrimSpace(string(stdout)), err
+}
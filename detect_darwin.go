@@ -0,0 +1,20 @@
+package supervisor
+
+// initVersion returns macOS version, launchd is not versioned separately
+func initVersion(backend string) string {
+	if out, err := detectOutput("sw_vers", "-productVersion"); err == nil && out != "" {
+		return "macOS " + out
+	}
+	return ""
+}
+
+// inContainer reports whether the host runs in a container
+func inContainer() bool {
+	return false
+}
+
+// userManagerReachable reports whether user systemd instance is reachable,
+// there is none on darwin
+func userManagerReachable() bool {
+	return false
+}
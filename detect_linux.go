@@ -0,0 +1,88 @@
+package supervisor
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// initVersion returns version of the init system managed by backend
+func initVersion(backend string) string {
+	switch backend {
+	case systemdBackend:
+		// systemd 245 (245.4-4ubuntu3)
+		if out, err := detectOutput("systemctl", "--version"); err == nil {
+			line := strings.SplitN(out, "\n", 2)[0]
+			fields := strings.Fields(line)
+			if len(fields) >= 2 {
+				return fields[0] + " " + fields[1]
+			}
+		}
+	case upstartBackend:
+		// initctl (upstart 1.12.1)
+		if out, err := detectOutput("initctl", "--version"); err == nil {
+			line := strings.SplitN(out, "\n", 2)[0]
+			if i, j := strings.IndexByte(line, '('), strings.IndexByte(line, ')'); i >= 0 && j > i {
+				return line[i+1 : j]
+			}
+		}
+	case procdBackend:
+		release := readRelease("/etc/openwrt_release")
+		if release["DISTRIB_ID"] != "" && release["DISTRIB_RELEASE"] != "" {
+			return release["DISTRIB_ID"] + " " + release["DISTRIB_RELEASE"]
+		}
+	}
+	return ""
+}
+
+// readRelease parses KEY='value' lines of release files
+func readRelease(path string) map[string]string {
+	release := make(map[string]string)
+	file, err := os.Open(path)
+	if err != nil {
+		return release
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, '='); i > 0 {
+			release[line[:i]] = strings.Trim(line[i+1:], `'"`)
+		}
+	}
+	return release
+}
+
+// inContainer reports whether the host runs in a container
+func inContainer() bool {
+	for _, path := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	// set by systemd-nspawn, lxc and podman
+	if environ, err := ioutil.ReadFile("/proc/1/environ"); err == nil {
+		for _, env := range strings.Split(string(environ), "\x00") {
+			if strings.HasPrefix(env, "container=") {
+				return true
+			}
+		}
+	}
+	if cgroup, err := ioutil.ReadFile("/proc/1/cgroup"); err == nil {
+		for _, runtime := range []string{"docker", "lxc", "kubepods", "containerd"} {
+			if strings.Contains(string(cgroup), runtime) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// userManagerReachable reports whether systemctl --user can reach the
+// user instance of systemd
+func userManagerReachable() bool {
+	_, err := detectOutput("systemctl", "--user", "show", "-p", "Version")
+	return err == nil
+}
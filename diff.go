@@ -0,0 +1,186 @@
+package supervisor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around changes
+const diffContext = 3
+
+// Diff returns unified diff from files installed for the service to files
+// rendered from cfg, empty if they match
+func Diff(cfg ServiceConfig) (string, error) {
+	desired, err := desiredFiles(cfg)
+	if err != nil {
+		return "", err
+	}
+	var diff strings.Builder
+	for _, a := range desired {
+		if upToDate(a) {
+			continue
+		}
+		var installed []byte
+		if info, err := os.Stat(a.Path); err == nil {
+			if info.Mode().Perm() != a.Mode.Perm() {
+				fmt.Fprintf(&diff, "old mode %04o %s\nnew mode %04o %s\n", info.Mode().Perm(), a.Path, a.Mode.Perm(), a.Path)
+			}
+			if installed, err = ioutil.ReadFile(a.Path); err != nil {
+				return "", err
+			}
+		}
+		diff.WriteString(unifiedDiff(a.Path+"\t(installed)", a.Path+"\t(desired)", splitLines(string(installed)), splitLines(string(a.Content))))
+	}
+	return diff.String(), nil
+}
+
+// HasDrifted reports whether files installed for the service differ from
+// files rendered from cfg
+func HasDrifted(cfg ServiceConfig) (bool, error) {
+	desired, err := desiredFiles(cfg)
+	if err != nil {
+		return false, err
+	}
+	for _, a := range desired {
+		if !upToDate(a) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// desiredFiles returns files rendered from cfg for installed service
+func desiredFiles(cfg ServiceConfig) ([]Action, error) {
+	s, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !s.IsInstalled() {
+		return nil, ErrNotInstalled
+	}
+	pl, ok := s.(planner)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	p, err := pl.plan(opReconcile, nil)
+	if err != nil {
+		return nil, err
+	}
+	return p.Files(), nil
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// edit is a line of the edit script, pos is the line index in old
+// and new text before the edit
+type edit struct {
+	op     byte
+	line   string
+	oldPos int
+	newPos int
+}
+
+// editScript returns shortest edit script from a to b
+func editScript(a, b []string) []edit {
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+	return edits
+}
+
+// unifiedDiff returns unified diff from a to b, empty if they are equal
+func unifiedDiff(oldName, newName string, a, b []string) string {
+	edits := editScript(a, b)
+
+	var hunks [][2]int
+	for i, e := range edits {
+		if e.op == ' ' {
+			continue
+		}
+		start, end := i-diffContext, i+diffContext+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(edits) {
+			end = len(edits)
+		}
+		if n := len(hunks); n > 0 && start <= hunks[n-1][1] {
+			hunks[n-1][1] = end
+		} else {
+			hunks = append(hunks, [2]int{start, end})
+		}
+	}
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks {
+		var oldCount, newCount int
+		for _, e := range edits[h[0]:h[1]] {
+			if e.op != '+' {
+				oldCount++
+			}
+			if e.op != '-' {
+				newCount++
+			}
+		}
+		first := edits[h[0]]
+		fmt.Fprintf(&diff, "@@ -%s +%s @@\n", hunkRange(first.oldPos, oldCount), hunkRange(first.newPos, newCount))
+		for _, e := range edits[h[0]:h[1]] {
+			diff.WriteByte(e.op)
+			diff.WriteString(e.line)
+			diff.WriteByte('\n')
+		}
+	}
+	return diff.String()
+}
+
+// hunkRange formats 0-based start and line count of a hunk
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
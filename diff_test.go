@@ -0,0 +1,84 @@
+package supervisor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"equal", "1\n2\n3\n", "1\n2\n3\n", ""},
+		{"insert", "1\n2\n3\n4\n5\n", "1\n2\nx\n3\n4\n5\n", `@@ -1,5 +1,6 @@
+ 1
+ 2
++x
+ 3
+ 4
+ 5
+`},
+		{"delete", "1\n2\n3\n4\n5\n", "1\n2\n4\n5\n", `@@ -1,5 +1,4 @@
+ 1
+ 2
+-3
+ 4
+ 5
+`},
+		{"replace", "1\n2\n3\n4\n5\n", "1\n2\nthree\n4\n5\n", `@@ -1,5 +1,5 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+`},
+		{"create", "", "a\nb\n", `@@ -0,0 +1,2 @@
++a
++b
+`},
+		{"remove", "a\nb\n", "", `@@ -1,2 +0,0 @@
+-a
+-b
+`},
+		{"hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n", "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n", `@@ -1,4 +1,4 @@
+-1
++one
+ 2
+ 3
+ 4
+@@ -9,4 +9,4 @@
+ 9
+ 10
+ 11
+-12
++twelve
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want != "" {
+				want = "--- old\n+++ new\n" + want
+			}
+			if got := unifiedDiff("old", "new", splitLines(tt.a), splitLines(tt.b)); got != want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestEditScript(t *testing.T) {
+	a := strings.Fields("a b c e")
+	b := strings.Fields("a c d e")
+	var ops strings.Builder
+	for _, e := range editScript(a, b) {
+		ops.WriteByte(e.op)
+		ops.WriteString(e.line)
+	}
+	if got, want := ops.String(), " a-b c+d e"; got != want {
+		t.Errorf("editScript() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+)
+
+// EnsureAction is the action taken by Ensure
+type EnsureAction string
+
+const (
+	EnsureCreated   EnsureAction = "created"
+	EnsureUpdated   EnsureAction = "updated"
+	EnsureUnchanged EnsureAction = "unchanged"
+)
+
+// EnsureResult describes what Ensure did
+type EnsureResult struct {
+	Action EnsureAction
+	// Files are paths of written files
+	Files []string
+	// Restarted reports whether the running service was restarted
+	Restarted bool
+}
+
+// planner is implemented by every backend
+type planner interface {
+	plan(op Operation, args []string) (*Plan, error)
+}
+
+// applier is implemented by backends applying plans other than by
+// running their commands through the Executor
+type applier interface {
+	apply(ctx context.Context, p *Plan) error
+}
+
+// Ensure installs the service described by cfg, or brings installed one
+// up to date. Files are rewritten, reloaded and the service restarted only
+// when rendered content differs from the installed one.
+func Ensure(cfg ServiceConfig) (EnsureResult, error) {
+	return EnsureContext(context.Background(), cfg)
+}
+
+// EnsureContext is Ensure with the context passed to backend commands
+func EnsureContext(ctx context.Context, cfg ServiceConfig) (EnsureResult, error) {
+	s, err := New(cfg)
+	if err != nil {
+		return EnsureResult{}, err
+	}
+	if !s.IsInstalled() {
+		p, err := s.Plan(OpInstall)
+		if err != nil {
+			return EnsureResult{}, err
+		}
+		if _, err := s.InstallContext(ctx); err != nil {
+			return EnsureResult{}, err
+		}
+		return EnsureResult{Action: EnsureCreated, Files: paths(p.Files())}, nil
+	}
+
+	pl, ok := s.(planner)
+	if !ok {
+		return EnsureResult{}, ErrNotSupported
+	}
+	desired, err := pl.plan(opReconcile, nil)
+	if err != nil {
+		return EnsureResult{}, err
+	}
+	changed := changedFiles(desired)
+	if len(changed.Files()) == 0 {
+		return EnsureResult{Action: EnsureUnchanged}, nil
+	}
+	if a, ok := s.(applier); ok {
+		err = a.apply(ctx, changed)
+	} else {
+		err = cfg.apply(ctx, changed)
+	}
+	if err != nil {
+		return EnsureResult{}, newOpError(string(opReconcile), cfg.Name, desired.Backend, err)
+	}
+
+	result := EnsureResult{Action: EnsureUpdated, Files: paths(changed.Files())}
+	if cfg.offline() {
+		return result, nil
+	}
+	if info, err := s.InspectContext(ctx); err == nil && info.State == StateRunning {
+		if _, err := s.RestartContext(ctx); err != nil {
+			return result, err
+		}
+		result.Restarted = true
+	}
+	return result, nil
+}
+
+// changedFiles returns plan without writes of files which are up to date
+func changedFiles(p *Plan) *Plan {
+	changed := *p
+	changed.Actions = nil
+	for _, a := range p.Actions {
+		if a.Kind == ActionWriteFile && upToDate(a) {
+			continue
+		}
+		changed.Actions = append(changed.Actions, a)
+	}
+	if len(changed.Files()) == 0 {
+		changed.Actions = nil
+	}
+	return &changed
+}
+
+// upToDate reports whether file of write action has the content and mode,
+// the version in the marker is not compared
+func upToDate(a Action) bool {
+	info, err := os.Stat(a.Path)
+	if err != nil || info.Mode().Perm() != a.Mode.Perm() {
+		return false
+	}
+	content, err := ioutil.ReadFile(a.Path)
+	return err == nil && bytes.Equal(withoutVersion(content), withoutVersion(a.Content))
+}
+
+func paths(actions []Action) []string {
+	var p []string
+	for _, a := range actions {
+		p = append(p, a.Path)
+	}
+	return p
+}
@@ -0,0 +1,58 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package supervisor
+
+import (
+	"os"
+	"testing"
+)
+
+func TestEnsure(t *testing.T) {
+	cfg := ServiceConfig{Backend: testBackend, Name: "app", Cmd: "/usr/bin/app", Description: "Test application", RootDir: testRoot(t)}
+	tests := []struct {
+		name    string
+		change  func(cfg *ServiceConfig)
+		action  EnsureAction
+		written bool
+	}{
+		{"install", func(*ServiceConfig) {}, EnsureCreated, true},
+		{"again", func(*ServiceConfig) {}, EnsureUnchanged, false},
+		{"change", func(cfg *ServiceConfig) { cfg.Args = []string{"-v"} }, EnsureUpdated, true},
+		{"again after change", func(*ServiceConfig) {}, EnsureUnchanged, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change(&cfg)
+			result, err := Ensure(cfg)
+			if err != nil {
+				t.Fatalf("Ensure() failed: %v", err)
+			}
+			if result.Action != tt.action || (len(result.Files) > 0) != tt.written {
+				t.Errorf("Ensure() = %+v, want %s", result, tt.action)
+			}
+			// offline installs have no running service
+			if result.Restarted {
+				t.Error("Ensure() restarted offline service")
+			}
+		})
+	}
+}
+
+func TestEnsureMode(t *testing.T) {
+	cfg := ServiceConfig{Backend: testBackend, Name: "app", Cmd: "/usr/bin/app", RootDir: testRoot(t)}
+	result, err := Ensure(cfg)
+	if err != nil {
+		t.Fatalf("Ensure() failed: %v", err)
+	}
+	// a file with the content but another mode is rewritten
+	if err := os.Chmod(result.Files[0], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := Ensure(cfg); result.Action != EnsureUpdated || err != nil {
+		t.Errorf("Ensure() after chmod = %+v, %v", result, err)
+	}
+	if result, err := Ensure(cfg); result.Action != EnsureUnchanged || err != nil {
+		t.Errorf("Ensure() after update = %+v, %v", result, err)
+	}
+}
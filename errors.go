@@ -0,0 +1,159 @@
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sentinel errors, use errors.Is to match them through *OpError
+var (
+	ErrNotInstalled     = errors.New("not installed")
+	ErrNotRunning       = errors.New("service is not running")
+	ErrOSNotSupported   = errors.New("OS not supported")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrAlreadyInstalled = errors.New("already installed")
+	ErrNotSupported     = errors.New("operation not supported")
+	ErrUnknownBackend   = errors.New("unknown backend")
+)
+
+// OpError describes failed service operation
+type OpError struct {
+	// Op is the operation, e.g. start or install
+	Op string
+	// Name of the service
+	Name string
+	// Backend is the init system name, e.g. systemd
+	Backend string
+	// Command is the failed command line, empty if no command failed
+	Command []string
+	// ExitCode of the command, -1 if it did not exit
+	ExitCode int
+	// Stderr captured from the command
+	Stderr string
+	Err    error
+}
+
+func (e *OpError) Error() string {
+	var parts []string
+	if e.Op != "" {
+		op := e.Op
+		if e.Name != "" {
+			op += " " + e.Name
+		}
+		if e.Backend != "" {
+			op += " (" + e.Backend + ")"
+		}
+		parts = append(parts, op)
+	}
+	if len(e.Command) > 0 {
+		parts = append(parts, fmt.Sprintf("%q", strings.Join(e.Command, " ")))
+	}
+	if e.Err != nil {
+		parts = append(parts, e.Err.Error())
+	}
+	if e.Stderr != "" {
+		parts = append(parts, e.Stderr)
+	}
+	return strings.Join(parts, ": ")
+}
+
+// Unwrap returns the cause
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches sentinel errors reported by the command through stderr
+func (e *OpError) Is(target error) bool {
+	switch target {
+	case ErrPermissionDenied:
+		return containsAny(e.Stderr, permissionMessages)
+	case ErrNotInstalled:
+		return containsAny(e.Stderr, notFoundMessages)
+	}
+	return false
+}
+
+var permissionMessages = []string{
+	"Access denied",
+	"Permission denied",
+	"Operation not permitted",
+	"Interactive authentication required",
+	"must be root",
+}
+
+var notFoundMessages = []string{
+	".service not found",
+	"could not be found",
+	"Unknown job",
+	"unrecognized service",
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// newOpError annotates err with operation, service name and backend
+func newOpError(op, name, backend string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*OpError); ok {
+		if e.Op == "" {
+			e.Op, e.Name, e.Backend = op, name, backend
+		}
+		return e
+	}
+	return &OpError{Op: op, Name: name, Backend: backend, ExitCode: -1, Err: err}
+}
+
+// commandError wraps error of the command run
+func commandError(err error, stderr []byte, command string, arguments ...string) *OpError {
+	code := -1
+	var exit interface{ ExitCode() int }
+	if errors.As(err, &exit) {
+		code = exit.ExitCode()
+	}
+	return &OpError{
+		Command:  append([]string{command}, arguments...),
+		ExitCode: code,
+		Stderr:   strings.TrimSpace(string(stderr)),
+		Err:      err,
+	}
+}
+
+// exitCode returns exit status of the failed command, -1 when err is not
+// an exit of a command
+func exitCode(err error) int {
+	var e *OpError
+	if errors.As(err, &e) {
+		return e.ExitCode
+	}
+	return -1
+}
+
+// TimeoutError is returned when a command did not finish before
+// the context deadline
+type TimeoutError struct {
+	Command string
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%q timed out: %v", e.Command, e.Err)
+}
+
+// Unwrap returns context.DeadlineExceeded
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports the error is caused by a timeout
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
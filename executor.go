@@ -0,0 +1,76 @@
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Command is an external command run by a backend
+type Command struct {
+	Path string
+	Args []string
+	// Env is appended to the environment of the current process
+	Env []string
+	// Stdin is written to the standard input of the command
+	Stdin []byte
+}
+
+// String returns the command line
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Path}, c.Args...), " ")
+}
+
+// Executor runs external commands on behalf of backends. It can be
+// replaced through ServiceConfig to record commands, fake them in tests
+// or route them through sudo or a remote shell.
+type Executor interface {
+	// Execute runs cmd and returns its stdout and stderr. When the command
+	// exits with non-zero status err should implement ExitCode() int,
+	// as *exec.ExitError does.
+	Execute(ctx context.Context, cmd Command) (stdout, stderr []byte, err error)
+}
+
+// LocalExecutor runs commands on the local host, it is the default Executor
+type LocalExecutor struct{}
+
+// waitDelay bounds waiting for output pipes after the command exited or was
+// killed, daemons started by init scripts can keep them open
+const waitDelay = 2 * time.Second
+
+// Execute runs cmd with os/exec, the process is killed once ctx is done.
+// Output written after the command exited by processes it left behind is
+// dropped after waitDelay.
+func (LocalExecutor) Execute(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+	c := exec.CommandContext(ctx, cmd.Path, cmd.Args...)
+	if len(cmd.Env) > 0 {
+		c.Env = append(os.Environ(), cmd.Env...)
+	}
+	if cmd.Stdin != nil {
+		c.Stdin = bytes.NewReader(cmd.Stdin)
+	}
+
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	c.WaitDelay = waitDelay
+
+	err := c.Run()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// the command succeeded, its pipes were held by its children
+		err = nil
+	}
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// executor returns configured Executor or LocalExecutor
+func (c *ServiceConfig) executor() Executor {
+	if c.Executor == nil {
+		return LocalExecutor{}
+	}
+	return c.Executor
+}
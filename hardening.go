@@ -0,0 +1,231 @@
+package supervisor
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// HardeningPreset selects a set of systemd sandboxing directives
+type HardeningPreset string
+
+const (
+	HardeningNone HardeningPreset = "none"
+	// HardeningBasic forbids privilege escalation, makes /usr, /boot and
+	// /etc read-only, home directories read-only and /tmp private
+	HardeningBasic HardeningPreset = "basic"
+	// HardeningStrict makes the whole file system read-only except
+	// ReadWritePaths, hides home directories and restricts address
+	// families and system calls to those of common services
+	HardeningStrict HardeningPreset = "strict"
+)
+
+// Hardening sandboxes the service, only systemd supports it. Switches
+// left empty are taken from Preset, set ones override it. Values are
+// those of the systemd directives of the same name.
+type Hardening struct {
+	Preset HardeningPreset
+	// NoNewPrivileges is yes or no
+	NoNewPrivileges string
+	// ProtectSystem is yes, full, strict or no
+	ProtectSystem string
+	// ProtectHome is yes, read-only, tmpfs or no
+	ProtectHome string
+	// PrivateTmp is yes or no
+	PrivateTmp string
+	// ReadWritePaths stay writable under read-only ProtectSystem and
+	// ProtectHome
+	ReadWritePaths          []string
+	CapabilityBoundingSet   []string
+	AmbientCapabilities     []string
+	RestrictAddressFamilies []string
+	SystemCallFilter        []string
+}
+
+var hardeningPresets = map[HardeningPreset]Hardening{
+	HardeningBasic: {
+		NoNewPrivileges: "yes",
+		ProtectSystem:   "full",
+		ProtectHome:     "read-only",
+		PrivateTmp:      "yes",
+	},
+	HardeningStrict: {
+		NoNewPrivileges:         "yes",
+		ProtectSystem:           "strict",
+		ProtectHome:             "yes",
+		PrivateTmp:              "yes",
+		RestrictAddressFamilies: []string{"AF_UNIX", "AF_INET", "AF_INET6", "AF_NETLINK"},
+		SystemCallFilter:        []string{"@system-service"},
+	},
+}
+
+// enabled reports whether h sets any directive
+func (h Hardening) enabled() bool {
+	e, err := h.effective()
+	return err != nil || e.directives() != ""
+}
+
+// effective returns switches of Preset overridden by the set ones
+func (h Hardening) effective() (Hardening, error) {
+	e := hardeningPresets[h.Preset]
+	switch h.Preset {
+	case "", HardeningNone, HardeningBasic, HardeningStrict:
+	default:
+		return e, fmt.Errorf("unknown hardening preset %q", h.Preset)
+	}
+	for _, s := range []struct{ dst, src *string }{
+		{&e.NoNewPrivileges, &h.NoNewPrivileges},
+		{&e.ProtectSystem, &h.ProtectSystem},
+		{&e.ProtectHome, &h.ProtectHome},
+		{&e.PrivateTmp, &h.PrivateTmp},
+	} {
+		if *s.src != "" {
+			*s.dst = *s.src
+		}
+	}
+	for _, l := range []struct{ dst, src *[]string }{
+		{&e.ReadWritePaths, &h.ReadWritePaths},
+		{&e.CapabilityBoundingSet, &h.CapabilityBoundingSet},
+		{&e.AmbientCapabilities, &h.AmbientCapabilities},
+		{&e.RestrictAddressFamilies, &h.RestrictAddressFamilies},
+		{&e.SystemCallFilter, &h.SystemCallFilter},
+	} {
+		if len(*l.src) > 0 {
+			*l.dst = *l.src
+		}
+	}
+	e.Preset = h.Preset
+	return e, nil
+}
+
+// directives returns unit directives of effective h
+func (h Hardening) directives() string {
+	var lines []string
+	for _, d := range h.fields() {
+		if *d.value != "" {
+			lines = append(lines, d.name+"="+*d.value)
+		}
+	}
+	for _, d := range h.lists() {
+		if len(*d.value) > 0 {
+			lines = append(lines, d.name+"="+strings.Join(*d.value, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+type hardeningField struct {
+	name  string
+	value *string
+}
+
+type hardeningList struct {
+	name  string
+	value *[]string
+}
+
+func (h *Hardening) fields() []hardeningField {
+	return []hardeningField{
+		{"NoNewPrivileges", &h.NoNewPrivileges},
+		{"ProtectSystem", &h.ProtectSystem},
+		{"ProtectHome", &h.ProtectHome},
+		{"PrivateTmp", &h.PrivateTmp},
+	}
+}
+
+func (h *Hardening) lists() []hardeningList {
+	return []hardeningList{
+		{"ReadWritePaths", &h.ReadWritePaths},
+		{"CapabilityBoundingSet", &h.CapabilityBoundingSet},
+		{"AmbientCapabilities", &h.AmbientCapabilities},
+		{"RestrictAddressFamilies", &h.RestrictAddressFamilies},
+		{"SystemCallFilter", &h.SystemCallFilter},
+	}
+}
+
+// parse sets directive key of the unit file, it reports whether key is a
+// hardening directive
+func (h *Hardening) parse(key, value string) bool {
+	for _, d := range h.fields() {
+		if d.name == key {
+			*d.value = value
+			return true
+		}
+	}
+	for _, d := range h.lists() {
+		if d.name == key {
+			*d.value = strings.Fields(value)
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateHardening returns warnings about WorkingDir and LogFile of cfg
+// made read-only or inaccessible by its Hardening. The service is likely
+// to fail writing them, add them to ReadWritePaths or relax the preset.
+func ValidateHardening(cfg ServiceConfig) []string {
+	h, err := cfg.Hardening.effective()
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var warnings []string
+	for _, p := range []struct{ name, path string }{
+		{"WorkingDir", cfg.WorkingDir},
+		{"LogFile", cfg.LogFile},
+	} {
+		if p.path == "" || !path.IsAbs(p.path) {
+			continue
+		}
+		if problem := h.restriction(path.Clean(p.path)); problem != "" {
+			warnings = append(warnings, fmt.Sprintf("%s %s is %s", p.name, p.path, problem))
+		}
+	}
+	return warnings
+}
+
+// restriction describes how h restricts access to p, empty if p is
+// writable by the service
+func (h Hardening) restriction(p string) string {
+	for _, rw := range h.ReadWritePaths {
+		if pathUnder(p, strings.TrimLeft(rw, "-+")) {
+			return ""
+		}
+	}
+	home := pathUnder(p, "/home") || pathUnder(p, "/root") || pathUnder(p, "/run/user")
+	switch {
+	case home && (h.ProtectHome == "yes" || h.ProtectHome == "true"):
+		return "inaccessible with ProtectHome=" + h.ProtectHome
+	case home && h.ProtectHome == "tmpfs":
+		return "hidden by an empty tmpfs with ProtectHome=tmpfs"
+	case home && h.ProtectHome == "read-only":
+		return "read-only with ProtectHome=read-only"
+	case (pathUnder(p, "/tmp") || pathUnder(p, "/var/tmp")) && (h.PrivateTmp == "yes" || h.PrivateTmp == "true"):
+		return "private to the service with PrivateTmp=" + h.PrivateTmp
+	}
+	readOnly := []string{"/usr", "/boot", "/efi"}
+	switch h.ProtectSystem {
+	case "full":
+		readOnly = append(readOnly, "/etc")
+	case "strict":
+		if !pathUnder(p, "/dev") && !pathUnder(p, "/proc") && !pathUnder(p, "/sys") {
+			return "read-only with ProtectSystem=strict"
+		}
+		return ""
+	case "yes", "true":
+	default:
+		return ""
+	}
+	for _, dir := range readOnly {
+		if pathUnder(p, dir) {
+			return "read-only with ProtectSystem=" + h.ProtectSystem
+		}
+	}
+	return ""
+}
+
+// pathUnder reports whether p is dir or inside of it
+func pathUnder(p, dir string) bool {
+	dir = path.Clean(dir)
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
@@ -0,0 +1,109 @@
+package supervisor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHardeningDirectives(t *testing.T) {
+	tests := []struct {
+		name      string
+		hardening Hardening
+		want      string
+	}{
+		{"none", Hardening{}, ""},
+		{"none preset", Hardening{Preset: HardeningNone}, ""},
+		{"basic", Hardening{Preset: HardeningBasic}, "NoNewPrivileges=yes\nProtectSystem=full\nProtectHome=read-only\nPrivateTmp=yes"},
+		{"strict", Hardening{Preset: HardeningStrict, ReadWritePaths: []string{"/var/lib/app"}},
+			"NoNewPrivileges=yes\nProtectSystem=strict\nProtectHome=yes\nPrivateTmp=yes\nReadWritePaths=/var/lib/app\n" +
+				"RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6 AF_NETLINK\nSystemCallFilter=@system-service"},
+		// set switches override the preset
+		{"override", Hardening{Preset: HardeningStrict, ProtectHome: "read-only", SystemCallFilter: []string{"@basic-io"}},
+			"NoNewPrivileges=yes\nProtectSystem=strict\nProtectHome=read-only\nPrivateTmp=yes\n" +
+				"RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6 AF_NETLINK\nSystemCallFilter=@basic-io"},
+		{"without preset", Hardening{PrivateTmp: "yes", AmbientCapabilities: []string{"CAP_NET_BIND_SERVICE"}},
+			"PrivateTmp=yes\nAmbientCapabilities=CAP_NET_BIND_SERVICE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := tt.hardening.effective()
+			if err != nil {
+				t.Fatalf("effective() failed: %v", err)
+			}
+			if got := e.directives(); got != tt.want {
+				t.Errorf("directives() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if tt.hardening.enabled() != (tt.want != "") {
+				t.Errorf("enabled() = %v", tt.hardening.enabled())
+			}
+		})
+	}
+
+	unknown := Hardening{Preset: "paranoid"}
+	if _, err := unknown.effective(); err == nil || !unknown.enabled() {
+		t.Errorf("unknown preset is accepted")
+	}
+}
+
+func TestHardeningParse(t *testing.T) {
+	e, _ := Hardening{Preset: HardeningStrict, ReadWritePaths: []string{"/var/lib/app", "-/run/app"}}.effective()
+	e.Preset = ""
+	var h Hardening
+	for _, d := range e.fields() {
+		if *d.value != "" && !h.parse(d.name, *d.value) {
+			t.Errorf("parse(%s) is not a hardening directive", d.name)
+		}
+	}
+	for _, d := range e.lists() {
+		if len(*d.value) > 0 {
+			h.parse(d.name, strings.Join(*d.value, " "))
+		}
+	}
+	if !reflect.DeepEqual(h, e) {
+		t.Errorf("parsed %+v, want %+v", h, e)
+	}
+	if h.parse("User", "app") {
+		t.Error("parse(User) is a hardening directive")
+	}
+}
+
+func TestValidateHardening(t *testing.T) {
+	tests := []struct {
+		name      string
+		hardening Hardening
+		dir, log  string
+		want      []string
+	}{
+		{"none", Hardening{}, "/home/pi/app", "/tmp/app.log", nil},
+		{"basic", Hardening{Preset: HardeningBasic}, "/home/pi/app", "/tmp/app.log", []string{
+			"WorkingDir /home/pi/app is read-only with ProtectHome=read-only",
+			"LogFile /tmp/app.log is private to the service with PrivateTmp=yes",
+		}},
+		{"basic etc", Hardening{Preset: HardeningBasic}, "/etc/app", "/var/log/app.log", []string{
+			"WorkingDir /etc/app is read-only with ProtectSystem=full",
+		}},
+		{"strict", Hardening{Preset: HardeningStrict}, "/root/app", "/var/log/app.log", []string{
+			"WorkingDir /root/app is inaccessible with ProtectHome=yes",
+			"LogFile /var/log/app.log is read-only with ProtectSystem=strict",
+		}},
+		{"read write paths", Hardening{Preset: HardeningStrict, ReadWritePaths: []string{"-/var/log"}}, "", "/var/log/app/app.log", nil},
+		{"tmpfs", Hardening{ProtectHome: "tmpfs"}, "/run/user/1000/app", "", []string{
+			"WorkingDir /run/user/1000/app is hidden by an empty tmpfs with ProtectHome=tmpfs",
+		}},
+		{"yes", Hardening{ProtectSystem: "yes"}, "/usr/share/app", "/etc/app.log", []string{
+			"WorkingDir /usr/share/app is read-only with ProtectSystem=yes",
+		}},
+		{"relative", Hardening{Preset: HardeningStrict}, "app", "", nil},
+		{"similar prefix", Hardening{Preset: HardeningBasic}, "/usrlocal/app", "/homes/app.log", nil},
+		{"unknown preset", Hardening{Preset: "paranoid"}, "", "", []string{`unknown hardening preset "paranoid"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ValidateHardening(ServiceConfig{Hardening: tt.hardening, WorkingDir: tt.dir, LogFile: tt.log})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateHardening() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package supervisor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Hooks are shell command lines run around the lifecycle of the service.
+// A failed PreStart command fails the start, failures of other hooks are
+// ignored by init scripts.
+type Hooks struct {
+	// PreStart commands run before the service is started
+	PreStart []string
+	// PostStart commands run once the service is started
+	PostStart []string
+	// PreStop commands run before the service is signalled to stop
+	PreStop []string
+	// PostStop commands run after the service stopped
+	PostStop []string
+	// Reload commands reload the service, not supported by upstart
+	Reload []string
+}
+
+// isZero reports whether no hook is set
+func (h Hooks) isZero() bool {
+	return len(h.PreStart)+len(h.PostStart)+len(h.PreStop)+len(h.PostStop)+len(h.Reload) == 0
+}
+
+// check rejects empty and multi-line commands, single quotes in commands
+// quoted by them and Reload when reload is false
+func (h Hooks) check(backend string, quoted, reload bool) error {
+	if !reload && len(h.Reload) > 0 {
+		return fmt.Errorf("%w: reload hook on %s", ErrNotSupported, backend)
+	}
+	for _, k := range h.hooks() {
+		for _, cmd := range *k.commands {
+			if strings.TrimSpace(cmd) == "" || strings.Contains(cmd, "\n") || quoted && strings.Contains(cmd, "'") {
+				return fmt.Errorf("hook command %q on %s", cmd, backend)
+			}
+		}
+	}
+	return nil
+}
+
+// hook is a list of Hooks with its name in a style
+type hook struct {
+	commands *[]string
+	names    [3]string
+}
+
+// hook styles, indexes of hook names
+const (
+	hookSystemd = iota
+	hookScript
+	hookRcCommon
+)
+
+func (h *Hooks) hooks() []hook {
+	return []hook{
+		{&h.PreStart, [3]string{"ExecStartPre", "pre_start", "pre_start"}},
+		{&h.PostStart, [3]string{"ExecStartPost", "post_start", "service_started"}},
+		{&h.PreStop, [3]string{"ExecStop", "pre_stop", "stop_service"}},
+		{&h.PostStop, [3]string{"ExecStopPost", "post_stop", "service_stopped"}},
+		{&h.Reload, [3]string{"ExecReload", "reload", "reload_service"}},
+	}
+}
+
+// systemd returns unit directives of hooks but PreStart, the template
+// puts it after removal of the pid file
+func (h Hooks) systemd() (string, string) {
+	var pre, lines []string
+	for i, k := range h.hooks() {
+		for _, cmd := range *k.commands {
+			line := k.names[hookSystemd] + "=/bin/sh -c '" + cmd + "'"
+			if i == 0 {
+				pre = append(pre, line)
+			} else {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return strings.Join(pre, "\n"), strings.Join(lines, "\n")
+}
+
+// parseSystemd parses directive key of the unit file, it reports whether
+// key is a hook
+func (h *Hooks) parseSystemd(key, value string) bool {
+	for _, k := range h.hooks() {
+		if k.names[hookSystemd] != key {
+			continue
+		}
+		if cmd, ok := between(value, "/bin/sh -c '", "'"); ok {
+			*k.commands = append(*k.commands, cmd)
+		}
+		return true
+	}
+	return false
+}
+
+// functions returns shell functions of set hooks named in style, their
+// bodies indented by indent
+func (h Hooks) functions(style int, indent string) string {
+	var funcs []string
+	for _, k := range h.hooks() {
+		if len(*k.commands) == 0 {
+			continue
+		}
+		lines := []string{k.names[style] + "() {"}
+		for _, cmd := range *k.commands {
+			lines = append(lines, indent+cmd)
+		}
+		funcs = append(funcs, strings.Join(append(lines, "}"), "\n"))
+	}
+	return strings.Join(funcs, "\n\n")
+}
+
+// parseFunctions parses shell functions of hooks named in style
+func (h *Hooks) parseFunctions(style int, content string) {
+	var commands *[]string
+	for _, line := range strings.Split(content, "\n") {
+		if commands != nil {
+			if line == "}" {
+				commands = nil
+				continue
+			}
+			*commands = append(*commands, strings.TrimSpace(line))
+			continue
+		}
+		for _, k := range h.hooks() {
+			if line == k.names[style]+"() {" {
+				commands = k.commands
+			}
+		}
+	}
+}
+
+// upstart returns script stanzas of hooks but Reload, a post-stop delay
+// of restarts ends the post-stop script
+func (h Hooks) upstart(delay int) string {
+	var stanzas []string
+	for i, k := range h.hooks()[:4] {
+		commands := *k.commands
+		if i == 3 && delay > 0 {
+			if len(commands) == 0 {
+				stanzas = append(stanzas, "post-stop exec sleep "+strconv.Itoa(delay))
+				continue
+			}
+			commands = append(commands[:len(commands):len(commands)], "sleep "+strconv.Itoa(delay))
+		}
+		if len(commands) == 0 {
+			continue
+		}
+		lines := []string{upstartHooks[i] + " script"}
+		for _, cmd := range commands {
+			lines = append(lines, "    "+cmd)
+		}
+		stanzas = append(stanzas, strings.Join(append(lines, "end script"), "\n"))
+	}
+	return strings.Join(stanzas, "\n")
+}
+
+// upstartHooks are stanzas of hooks in order of Hooks.hooks
+var upstartHooks = []string{"pre-start", "post-start", "pre-stop", "post-stop"}
+
+// parseUpstart parses script stanzas of hooks, the trailing sleep of the
+// post-stop script is returned as restart delay in seconds
+func (h *Hooks) parseUpstart(content string) int {
+	var commands *[]string
+	hooks := h.hooks()
+	for _, line := range strings.Split(content, "\n") {
+		if commands != nil {
+			if line == "end script" {
+				commands = nil
+				continue
+			}
+			*commands = append(*commands, strings.TrimSpace(line))
+			continue
+		}
+		for i, stanza := range upstartHooks {
+			if line == stanza+" script" {
+				commands = hooks[i].commands
+			}
+		}
+	}
+	delay := 0
+	if n := len(h.PostStop); n > 0 && strings.HasPrefix(h.PostStop[n-1], "sleep ") {
+		if v, err := strconv.Atoi(strings.TrimPrefix(h.PostStop[n-1], "sleep ")); err == nil {
+			delay = v
+			h.PostStop = h.PostStop[:n-1]
+		}
+	}
+	if len(h.PostStop) == 0 {
+		h.PostStop = nil
+	}
+	return delay
+}
@@ -0,0 +1,174 @@
+package supervisor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testHooks = Hooks{
+	PreStart:  []string{"mkdir -p /run/app", "chown app /run/app"},
+	PostStart: []string{"logger started"},
+	PreStop:   []string{"app --drain"},
+	PostStop:  []string{"rm -rf /run/app"},
+	Reload:    []string{"kill -USR1 $(cat /run/app/pid)"},
+}
+
+func TestHooksCheck(t *testing.T) {
+	tests := []struct {
+		name           string
+		hooks          Hooks
+		quoted, reload bool
+		ok             bool
+	}{
+		{"none", Hooks{}, true, false, true},
+		{"all", testHooks, false, true, true},
+		{"reload unsupported", Hooks{Reload: []string{"true"}}, false, false, false},
+		{"empty", Hooks{PreStart: []string{" "}}, false, true, false},
+		{"multi-line", Hooks{PostStop: []string{"a\nb"}}, false, true, false},
+		{"single quote", Hooks{PreStop: []string{"echo 'bye'"}}, true, true, false},
+		{"single quote unquoted", Hooks{PreStop: []string{"echo 'bye'"}}, false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.hooks.check("test", tt.quoted, tt.reload); (err == nil) != tt.ok {
+				t.Errorf("check() returned %v", err)
+			}
+		})
+	}
+}
+
+func TestHooksSystemd(t *testing.T) {
+	pre, lines := testHooks.systemd()
+	wantPre := "ExecStartPre=/bin/sh -c 'mkdir -p /run/app'\nExecStartPre=/bin/sh -c 'chown app /run/app'"
+	wantLines := `ExecStartPost=/bin/sh -c 'logger started'
+ExecStop=/bin/sh -c 'app --drain'
+ExecStopPost=/bin/sh -c 'rm -rf /run/app'
+ExecReload=/bin/sh -c 'kill -USR1 $(cat /run/app/pid)'`
+	if pre != wantPre || lines != wantLines {
+		t.Errorf("systemd() =\n%s\n%s\nwant\n%s\n%s", pre, lines, wantPre, wantLines)
+	}
+
+	var h Hooks
+	for _, line := range strings.Split(pre+"\n"+lines, "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if !h.parseSystemd(kv[0], kv[1]) {
+			t.Errorf("parseSystemd(%q) is not a hook", line)
+		}
+	}
+	if !reflect.DeepEqual(h, testHooks) {
+		t.Errorf("parsed %+v, want %+v", h, testHooks)
+	}
+	if h.parseSystemd("ExecStart", "/usr/bin/app") {
+		t.Error("parseSystemd(ExecStart) is a hook")
+	}
+}
+
+func TestHooksFunctions(t *testing.T) {
+	tests := []struct {
+		name   string
+		style  int
+		indent string
+		want   string
+	}{
+		{"script", hookScript, "    ", `pre_start() {
+    mkdir -p /run/app
+    chown app /run/app
+}
+
+post_start() {
+    logger started
+}
+
+pre_stop() {
+    app --drain
+}
+
+post_stop() {
+    rm -rf /run/app
+}
+
+reload() {
+    kill -USR1 $(cat /run/app/pid)
+}`},
+		{"rc.common", hookRcCommon, "  ", `pre_start() {
+  mkdir -p /run/app
+  chown app /run/app
+}
+
+service_started() {
+  logger started
+}
+
+stop_service() {
+  app --drain
+}
+
+service_stopped() {
+  rm -rf /run/app
+}
+
+reload_service() {
+  kill -USR1 $(cat /run/app/pid)
+}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := testHooks.functions(tt.style, tt.indent)
+			if got != tt.want {
+				t.Errorf("functions() =\n%s\nwant\n%s", got, tt.want)
+			}
+			var h Hooks
+			h.parseFunctions(tt.style, "#!/bin/sh\n"+got+"\n\ncase \"$1\" in\nesac\n")
+			if !reflect.DeepEqual(h, testHooks) {
+				t.Errorf("parsed %+v, want %+v", h, testHooks)
+			}
+		})
+	}
+	if got := (Hooks{}).functions(hookScript, "    "); got != "" {
+		t.Errorf("functions() of no hooks = %q", got)
+	}
+}
+
+func TestHooksUpstart(t *testing.T) {
+	hooks := testHooks
+	hooks.Reload = nil
+	tests := []struct {
+		name  string
+		hooks Hooks
+		delay int
+		want  string
+	}{
+		{"none", Hooks{}, 0, ""},
+		{"delay only", Hooks{}, 5, "post-stop exec sleep 5"},
+		{"all", hooks, 5, `pre-start script
+    mkdir -p /run/app
+    chown app /run/app
+end script
+post-start script
+    logger started
+end script
+pre-stop script
+    app --drain
+end script
+post-stop script
+    rm -rf /run/app
+    sleep 5
+end script`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.hooks.upstart(tt.delay)
+			if got != tt.want {
+				t.Errorf("upstart() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if tt.hooks.isZero() {
+				return
+			}
+			var h Hooks
+			if delay := h.parseUpstart("description \"app\"\n" + got + "\n"); delay != tt.delay || !reflect.DeepEqual(h, tt.hooks) {
+				t.Errorf("parseUpstart() = %+v, %d, want %+v, %d", h, delay, tt.hooks, tt.delay)
+			}
+		})
+	}
+}
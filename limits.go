@@ -0,0 +1,183 @@
+package supervisor
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Unlimited removes a limit of Limits
+const Unlimited = ^uint64(0)
+
+// Limits are resource limits of the service, zero values leave the
+// limit to the init system
+type Limits struct {
+	// MemoryMax in bytes is the cgroup memory limit on systemd and the
+	// address space (data size on launchd) limit on other backends
+	MemoryMax uint64
+	// CPUQuota in percent of one CPU, only systemd supports it
+	CPUQuota uint
+	// NOFILE is the maximum number of open files
+	NOFILE uint64
+	// NPROC is the maximum number of processes of the user
+	NPROC uint64
+	// Core is the maximum core dump size in bytes. Init scripts only
+	// take Unlimited, ulimit -c counts blocks of a size that depends on
+	// the shell.
+	Core uint64
+}
+
+// limit styles, indexes of rlimit names
+const (
+	limitSystemd = iota
+	limitProcd
+	limitUpstart
+	limitUlimit
+	limitLaunchd
+)
+
+// rlimit is a limit with its names in every style
+type rlimit struct {
+	value *uint64
+	names [5]string
+	// unit of ulimit values in bytes, dash and busybox count -c in
+	// 512 byte blocks and bash in 1024 byte ones unless POSIX
+	unit uint64
+}
+
+func (l *Limits) rlimits() []rlimit {
+	return []rlimit{
+		{&l.MemoryMax, [5]string{"MemoryMax", "as", "as", "-v", "Data"}, 1024},
+		{&l.NOFILE, [5]string{"LimitNOFILE", "nofile", "nofile", "-n", "NumberOfFiles"}, 1},
+		{&l.NPROC, [5]string{"LimitNPROC", "nproc", "nproc", "-u", "NumberOfProcesses"}, 1},
+		{&l.Core, [5]string{"LimitCORE", "core", "core", "-c", "Core"}, 512},
+	}
+}
+
+func formatLimit(v uint64, unlimited string) string {
+	if v == Unlimited {
+		return unlimited
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+func parseLimit(s string) (uint64, bool) {
+	if s == "infinity" || s == "unlimited" {
+		return Unlimited, true
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	return v, err == nil
+}
+
+// systemd returns unit directives
+func (l Limits) systemd() string {
+	var lines []string
+	for i, r := range l.rlimits() {
+		if *r.value != 0 {
+			lines = append(lines, r.names[limitSystemd]+"="+formatLimit(*r.value, "infinity"))
+		}
+		if i == 0 && l.CPUQuota != 0 {
+			lines = append(lines, "CPUQuota="+strconv.FormatUint(uint64(l.CPUQuota), 10)+"%")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// procd returns arguments of procd_set_param limits
+func (l Limits) procd() string {
+	var params []string
+	for _, r := range l.rlimits() {
+		if *r.value != 0 {
+			v := formatLimit(*r.value, "unlimited")
+			if *r.value != Unlimited {
+				v += " " + v
+			}
+			params = append(params, r.names[limitProcd]+`="`+v+`"`)
+		}
+	}
+	return strings.Join(params, " ")
+}
+
+// upstart returns limit stanzas
+func (l Limits) upstart() string {
+	var lines []string
+	for _, r := range l.rlimits() {
+		if *r.value != 0 {
+			v := formatLimit(*r.value, "unlimited")
+			lines = append(lines, "limit "+r.names[limitUpstart]+" "+v+" "+v)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ulimit returns ulimit calls of init scripts, each line prefixed with
+// indent
+func (l Limits) ulimit(indent string) string {
+	var lines []string
+	for _, r := range l.rlimits() {
+		if v := *r.value; v != 0 {
+			if v != Unlimited {
+				v = (v + r.unit - 1) / r.unit
+			}
+			lines = append(lines, indent+"ulimit "+r.names[limitUlimit]+" "+formatLimit(v, "unlimited"))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkUlimit rejects limits which ulimit cannot set in every shell
+func (l Limits) checkUlimit(backend string) error {
+	if l.Core != 0 && l.Core != Unlimited {
+		return fmt.Errorf("%w: core size other than unlimited on %s scripts", ErrNotSupported, backend)
+	}
+	return nil
+}
+
+// launchd returns SoftResourceLimits, launchd cannot express Unlimited
+func (l Limits) launchd() map[string]uint64 {
+	limits := make(map[string]uint64)
+	for _, r := range l.rlimits() {
+		if v := *r.value; v != 0 && v != Unlimited {
+			limits[r.names[limitLaunchd]] = v
+		}
+	}
+	return limits
+}
+
+// parse sets limit named name in style to value, it reports whether name
+// is a limit
+func (l *Limits) parse(style int, name, value string) bool {
+	if style == limitSystemd && name == "CPUQuota" {
+		if v, err := strconv.ParseUint(strings.TrimSuffix(value, "%"), 10, 32); err == nil {
+			l.CPUQuota = uint(v)
+		}
+		return true
+	}
+	for _, r := range l.rlimits() {
+		if r.names[style] != name {
+			continue
+		}
+		// procd and upstart give soft and hard limit, the soft one is kept
+		if i := strings.IndexByte(value, ' '); i >= 0 {
+			value = value[:i]
+		}
+		if v, ok := parseLimit(value); ok {
+			if style == limitUlimit && v != Unlimited {
+				v *= r.unit
+			}
+			*r.value = v
+		}
+		return true
+	}
+	return false
+}
+
+var quotedParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// parseProcd parses arguments of procd_set_param limits
+func (l *Limits) parseProcd(params string) {
+	for _, m := range quotedParam.FindAllStringSubmatch(params, -1) {
+		l.parse(limitProcd, m[1], m[2])
+	}
+}
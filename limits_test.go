@@ -0,0 +1,127 @@
+package supervisor
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLimitsRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		systemd string
+		procd   string
+		upstart string
+		ulimit  string
+		launchd map[string]uint64
+	}{
+		{"none", Limits{}, "", "", "", "", map[string]uint64{}},
+		{
+			"all",
+			Limits{MemoryMax: 512 << 20, CPUQuota: 50, NOFILE: 4096, NPROC: 64, Core: Unlimited},
+			"MemoryMax=536870912\nCPUQuota=50%\nLimitNOFILE=4096\nLimitNPROC=64\nLimitCORE=infinity",
+			`as="536870912 536870912" nofile="4096 4096" nproc="64 64" core="unlimited"`,
+			"limit as 536870912 536870912\nlimit nofile 4096 4096\nlimit nproc 64 64\nlimit core unlimited unlimited",
+			// ulimit -v counts kilobytes
+			"ulimit -v 524288\nulimit -n 4096\nulimit -u 64\nulimit -c unlimited",
+			map[string]uint64{"Data": 512 << 20, "NumberOfFiles": 4096, "NumberOfProcesses": 64},
+		},
+		{
+			"unlimited",
+			Limits{MemoryMax: Unlimited, NOFILE: Unlimited},
+			"MemoryMax=infinity\nLimitNOFILE=infinity",
+			`as="unlimited" nofile="unlimited"`,
+			"limit as unlimited unlimited\nlimit nofile unlimited unlimited",
+			"ulimit -v unlimited\nulimit -n unlimited",
+			map[string]uint64{},
+		},
+		{
+			"rounded",
+			Limits{MemoryMax: 1000},
+			"MemoryMax=1000",
+			`as="1000 1000"`,
+			"limit as 1000 1000",
+			"ulimit -v 1",
+			map[string]uint64{"Data": 1000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limits.systemd(); got != tt.systemd {
+				t.Errorf("systemd() = %q, want %q", got, tt.systemd)
+			}
+			if got := tt.limits.procd(); got != tt.procd {
+				t.Errorf("procd() = %q, want %q", got, tt.procd)
+			}
+			if got := tt.limits.upstart(); got != tt.upstart {
+				t.Errorf("upstart() = %q, want %q", got, tt.upstart)
+			}
+			if got := tt.limits.ulimit(""); got != tt.ulimit {
+				t.Errorf("ulimit() = %q, want %q", got, tt.ulimit)
+			}
+			if got := tt.limits.launchd(); !reflect.DeepEqual(got, tt.launchd) {
+				t.Errorf("launchd() = %v, want %v", got, tt.launchd)
+			}
+		})
+	}
+}
+
+func TestLimitsParse(t *testing.T) {
+	want := Limits{MemoryMax: 512 << 20, NOFILE: 4096, NPROC: 64, Core: Unlimited}
+	withQuota := want
+	withQuota.CPUQuota = 50
+
+	var systemd Limits
+	for _, line := range strings.Split(withQuota.systemd(), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if !systemd.parse(limitSystemd, kv[0], kv[1]) {
+			t.Errorf("parse(%q) is not a limit", line)
+		}
+	}
+	if systemd != withQuota {
+		t.Errorf("systemd limits parsed as %+v, want %+v", systemd, withQuota)
+	}
+
+	var procd Limits
+	procd.parseProcd(want.procd())
+	if procd != want {
+		t.Errorf("procd limits parsed as %+v, want %+v", procd, want)
+	}
+
+	var upstart, ulimit Limits
+	for _, line := range strings.Split(want.upstart(), "\n") {
+		f := strings.SplitN(strings.TrimPrefix(line, "limit "), " ", 2)
+		upstart.parse(limitUpstart, f[0], f[1])
+	}
+	for _, line := range strings.Split(want.ulimit(""), "\n") {
+		f := strings.Fields(line)
+		ulimit.parse(limitUlimit, f[1], f[2])
+	}
+	if upstart != want || ulimit != want {
+		t.Errorf("limits parsed as %+v and %+v, want %+v", upstart, ulimit, want)
+	}
+
+	if (&Limits{}).parse(limitSystemd, "LimitSTACK", "8M") {
+		t.Error("parse(LimitSTACK) is a limit")
+	}
+}
+
+func TestLimitsCheckUlimit(t *testing.T) {
+	tests := []struct {
+		limits Limits
+		ok     bool
+	}{
+		{Limits{}, true},
+		{Limits{Core: Unlimited, NOFILE: 1024}, true},
+		// the unit of ulimit -c differs between dash and bash
+		{Limits{Core: 1 << 20}, false},
+	}
+	for _, tt := range tests {
+		err := tt.limits.checkUlimit("sysv")
+		if (err == nil) != tt.ok || (err != nil && !errors.Is(err, ErrNotSupported)) {
+			t.Errorf("checkUlimit(%+v) returned %v", tt.limits, err)
+		}
+	}
+}
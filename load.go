@@ -0,0 +1,103 @@
+package supervisor
+
+import (
+	"regexp"
+	"strings"
+)
+
+// loader is implemented by backends parsing their installed files
+type loader interface {
+	load() (ServiceConfig, error)
+}
+
+// Load returns installed service with the configuration parsed from its
+// unit file, init script, job config or property list
+func Load(name string) (Service, error) {
+	cfg, err := LoadConfig(ServiceConfig{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg)
+}
+
+// LoadConfig parses configuration of installed service cfg.Name. Backend,
+// RootDir and Executor of cfg select where the service is looked up.
+func LoadConfig(cfg ServiceConfig) (ServiceConfig, error) {
+	s, err := New(cfg)
+	if err != nil {
+		return cfg, err
+	}
+	if !s.IsInstalled() {
+		return cfg, ErrNotInstalled
+	}
+	l, ok := s.(loader)
+	if !ok {
+		return cfg, ErrNotSupported
+	}
+	loaded, err := l.load()
+	// Restart and RestartSec follow the loaded RestartPolicy
+	return loaded.withDefaults(), err
+}
+
+// splitCommandLine reverses "{{.Cmd}} {{.Args}}" of the templates
+func splitCommandLine(s string) (string, []string) {
+	if strings.HasSuffix(s, " ") {
+		return strings.TrimSuffix(s, " "), nil
+	}
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, nil
+	}
+	return s[:i], strings.Split(s[i+1:], " ")
+}
+
+// splitLogRedirect splits "command >>log" at the last redirection
+func splitLogRedirect(s, redirect string) (string, string) {
+	i := strings.LastIndex(s, redirect)
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i+len(redirect):]
+}
+
+// between returns s without prefix and suffix, false if s lacks either
+func between(s, prefix, suffix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) || !strings.HasSuffix(s, suffix) || len(s) < len(prefix)+len(suffix) {
+		return "", false
+	}
+	return s[len(prefix) : len(s)-len(suffix)], true
+}
+
+// parseChuid returns user and group of -c or --chuid option of
+// start-stop-daemon command line
+func parseChuid(line string) (string, string) {
+	fields := strings.Fields(line)
+	for i, f := range fields {
+		if f == "--" {
+			break
+		}
+		if (f == "-c" || f == "--chuid") && i+1 < len(fields) {
+			ug := strings.SplitN(fields[i+1], ":", 2)
+			if len(ug) == 2 {
+				return ug[0], ug[1]
+			}
+			return ug[0], ""
+		}
+	}
+	return "", ""
+}
+
+var quotedEnv = regexp.MustCompile(`"([^"=]+)=([^"]*)"`)
+
+// parseQuotedEnv reverses environSystemd and environProcd
+func parseQuotedEnv(s string) map[string]string {
+	matches := quotedEnv.FindAllStringSubmatch(s, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	env := make(map[string]string, len(matches))
+	for _, m := range matches {
+		env[m[1]] = m[2]
+	}
+	return env
+}
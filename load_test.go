@@ -0,0 +1,52 @@
+package supervisor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testRoot returns a temporary root with the service directories of
+// every backend
+func testRoot(t *testing.T) string {
+	root := t.TempDir()
+	for _, dir := range []string{"etc/init.d", "etc/init", "etc/rc.d", "etc/systemd/system", "Library/LaunchDaemons",
+		"etc/rc0.d", "etc/rc1.d", "etc/rc2.d", "etc/rc3.d", "etc/rc4.d", "etc/rc5.d", "etc/rc6.d"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// roundTrip installs cfg into a temporary root and loads it back
+func roundTrip(t *testing.T, cfg ServiceConfig) ServiceConfig {
+	root := testRoot(t)
+	cfg.RootDir = root
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Install(); err != nil {
+		t.Fatalf("Install() failed: %v", err)
+	}
+	loaded, err := LoadConfig(ServiceConfig{Name: cfg.Name, Backend: cfg.Backend, RootDir: root})
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	return loaded
+}
+
+// checkRoundTrip checks cfg is loaded back as installed
+func checkRoundTrip(t *testing.T, cfg ServiceConfig) {
+	got := roundTrip(t, cfg)
+	want := cfg.withDefaults()
+	want.RootDir = got.RootDir
+	w, g := reflect.ValueOf(want), reflect.ValueOf(got)
+	for i := 0; i < w.NumField(); i++ {
+		if !reflect.DeepEqual(w.Field(i).Interface(), g.Field(i).Interface()) {
+			t.Errorf("loaded %s = %#v, want %#v", w.Type().Field(i).Name, g.Field(i).Interface(), w.Field(i).Interface())
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the library recorded in generated files
+const Version = "0.1.0"
+
+// markerTag starts the marker comment of generated files
+const markerTag = "supervisor:"
+
+// markerLines limits how deep into a file the marker is looked up
+const markerLines = 5
+
+// Marker is embedded as a comment in every unit file, init script, job
+// config and property list generated by this library
+type Marker struct {
+	Version string            `json:"version"`
+	Created time.Time         `json:"created"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// marker returns the marker of file, the creation time of an installed
+// file is kept so re-rendering it yields the same content
+func (c *ServiceConfig) marker(file string) Marker {
+	m := Marker{
+		Version: Version,
+		Created: time.Now().UTC().Truncate(time.Second),
+		Labels:  c.Labels,
+	}
+	if old, ok := readMarker(c.path(file)); ok {
+		m.Created = old.Created
+	}
+	return m
+}
+
+// comment renders m as a single line comment between prefix and suffix
+func (m Marker) comment(prefix, suffix string) string {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	// "--" is not allowed inside XML comments, it may only occur in
+	// JSON strings where the escaped form is equivalent
+	s := strings.Replace(string(b), "--", `-\u002d`, -1)
+	return prefix + markerTag + " " + s + suffix
+}
+
+// matches reports whether m carries all labels
+func (m Marker) matches(labels map[string]string) bool {
+	for k, v := range labels {
+		if l, ok := m.Labels[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
+
+func readMarker(file string) (Marker, bool) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Marker{}, false
+	}
+	return parseMarker(content)
+}
+
+// parseMarker finds the marker in the first lines of content
+func parseMarker(content []byte) (Marker, bool) {
+	for i, line := range strings.SplitN(string(content), "\n", markerLines+1) {
+		if i == markerLines {
+			break
+		}
+		j := strings.Index(line, markerTag)
+		if j < 0 {
+			continue
+		}
+		var m Marker
+		dec := json.NewDecoder(strings.NewReader(line[j+len(markerTag):]))
+		if err := dec.Decode(&m); err == nil && m.Version != "" {
+			return m, true
+		}
+	}
+	return Marker{}, false
+}
+
+// withoutVersion returns content with the version of its marker removed.
+// The marker records the library that wrote the file, files which differ
+// in it only are up to date and not rewritten after an upgrade.
+func withoutVersion(content []byte) []byte {
+	m, ok := parseMarker(content)
+	if !ok {
+		return content
+	}
+	lines := strings.SplitN(string(content), "\n", markerLines+1)
+	for i, line := range lines {
+		if i < markerLines && strings.Contains(line, markerTag) {
+			lines[i] = strings.Replace(line, `"version":`+strconv.Quote(m.Version), `"version":""`, 1)
+			break
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// ListFilter selects services returned by List
+type ListFilter struct {
+	// Labels must all be present in the marker with equal values
+	Labels map[string]string
+	// Backend, Scope, RootDir and Executor are set on returned services
+	Backend  string
+	Scope    Scope
+	RootDir  string
+	Executor Executor
+}
+
+// lister is implemented by backends keeping their files in one directory
+type lister interface {
+	// serviceDir returns the directory and file name suffix of services
+	serviceDir() (string, string)
+}
+
+// List returns services installed by this library whose marker labels
+// match filter. Configuration of each service is parsed from its files.
+func List(filter ListFilter) ([]Service, error) {
+	cfg := ServiceConfig{
+		Backend:  filter.Backend,
+		Scope:    filter.Scope,
+		RootDir:  filter.RootDir,
+		Executor: filter.Executor,
+	}
+	s, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := s.(lister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	dir, suffix := l.serviceDir()
+	dir = cfg.path(dir)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var services []Service
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), suffix) {
+			continue
+		}
+		m, ok := readMarker(filepath.Join(dir, e.Name()))
+		if !ok || !m.matches(filter.Labels) {
+			continue
+		}
+		c := cfg
+		c.Name = strings.TrimSuffix(e.Name(), suffix)
+		if loaded, err := LoadConfig(c); err == nil {
+			c = loaded
+		}
+		svc, err := New(c)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
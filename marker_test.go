@@ -0,0 +1,123 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package supervisor
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMarker(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	m := Marker{Version: "1.2.3", Created: created, Labels: map[string]string{"team": "edge", "note": "a--b"}}
+	tests := []struct {
+		name    string
+		content string
+		want    Marker
+		ok      bool
+	}{
+		{"shell", "#!/bin/sh\n" + m.comment("# ", "") + "\n", m, true},
+		{"xml", `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + m.comment("<!-- ", " -->") + "\n<plist>", m, true},
+		{"no labels", "[Unit]\n" + Marker{Version: "1.2.3", Created: created}.comment("# ", ""), Marker{Version: "1.2.3", Created: created}, true},
+		{"too deep", "1\n2\n3\n4\n5\n" + m.comment("# ", ""), Marker{}, false},
+		{"no version", `# supervisor: {"created":"2024-03-01T12:00:00Z"}`, Marker{}, false},
+		{"not json", "# supervisor: managed", Marker{}, false},
+		{"none", "#!/bin/sh\necho hi\n", Marker{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseMarker([]byte(tt.content))
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMarker() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMarkerComment(t *testing.T) {
+	m := Marker{Version: "1.2.3", Labels: map[string]string{"note": "a--b"}}
+	// -- may not occur inside XML comments
+	if c := m.comment("<!-- ", " -->"); strings.Contains(strings.TrimSuffix(strings.TrimPrefix(c, "<!-- "), " -->"), "--") {
+		t.Errorf("comment() = %q contains --", c)
+	}
+}
+
+func TestMarkerMatches(t *testing.T) {
+	m := Marker{Version: Version, Labels: map[string]string{"team": "edge", "env": "prod"}}
+	tests := []struct {
+		labels map[string]string
+		want   bool
+	}{
+		{nil, true},
+		{map[string]string{"team": "edge"}, true},
+		{map[string]string{"team": "edge", "env": "prod"}, true},
+		{map[string]string{"team": "core"}, false},
+		{map[string]string{"team": "edge", "site": "tokyo"}, false},
+		{map[string]string{"env": ""}, false},
+	}
+	for _, tt := range tests {
+		if got := m.matches(tt.labels); got != tt.want {
+			t.Errorf("matches(%v) = %v, want %v", tt.labels, got, tt.want)
+		}
+	}
+	if (Marker{Version: Version}).matches(map[string]string{"team": ""}) {
+		t.Error("marker without labels matches an empty label")
+	}
+}
+
+func TestWithoutVersion(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	render := func(version string, labels map[string]string) []byte {
+		return []byte("#!/bin/sh\n" + Marker{Version: version, Created: created, Labels: labels}.comment("# ", "") + "\necho hi\n")
+	}
+	labels := map[string]string{"team": "edge"}
+	if a, b := withoutVersion(render("0.1.0", labels)), withoutVersion(render("0.2.0", labels)); string(a) != string(b) {
+		t.Errorf("contents differing in version only differ:\n%s\n%s", a, b)
+	}
+	if a, b := withoutVersion(render("0.1.0", labels)), withoutVersion(render("0.1.0", nil)); string(a) == string(b) {
+		t.Error("contents differing in labels are equal")
+	}
+	if content := []byte("#!/bin/sh\necho hi\n"); string(withoutVersion(content)) != string(content) {
+		t.Errorf("withoutVersion() changed content without marker")
+	}
+}
+
+func TestDriftVersion(t *testing.T) {
+	cfg := ServiceConfig{Backend: testBackend, Name: "app", Cmd: "/usr/bin/app", RootDir: testRoot(t), Labels: map[string]string{"team": "edge"}}
+	if _, err := Ensure(cfg); err != nil {
+		t.Fatalf("Ensure() failed: %v", err)
+	}
+	desired, err := desiredFiles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// files written by an older release are up to date
+	for _, f := range desired {
+		content, err := ioutil.ReadFile(f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := strings.Replace(string(content), `"version":"`+Version+`"`, `"version":"0.0.1"`, 1)
+		if err := ioutil.WriteFile(f.Path, []byte(old), f.Mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if drifted, err := HasDrifted(cfg); drifted || err != nil {
+		t.Errorf("HasDrifted() after version change = %v, %v", drifted, err)
+	}
+	if diff, err := Diff(cfg); diff != "" || err != nil {
+		t.Errorf("Diff() after version change = %q, %v", diff, err)
+	}
+	if result, err := Ensure(cfg); result.Action != EnsureUnchanged || err != nil {
+		t.Errorf("Ensure() after version change = %+v, %v", result, err)
+	}
+
+	cfg.Labels = map[string]string{"team": "core"}
+	if drifted, err := HasDrifted(cfg); !drifted || err != nil {
+		t.Errorf("HasDrifted() after label change = %v, %v", drifted, err)
+	}
+}
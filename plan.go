@@ -0,0 +1,243 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Operation is a service operation which changes the system
+type Operation string
+
+const (
+	OpInstall       Operation = "install"
+	OpRemove        Operation = "remove"
+	OpUpdateEnviron Operation = "update"
+	// OpEnable and OpDisable switch starting of the installed service at
+	// boot. OpInstall enables it already except procd scripts of services
+	// other than the agent.
+	OpEnable  Operation = "enable"
+	OpDisable Operation = "disable"
+
+	// opReconcile writes files of installed service and makes the init
+	// system reread them
+	opReconcile Operation = "reconcile"
+)
+
+// ActionKind is a kind of side effect
+type ActionKind int
+
+const (
+	ActionWriteFile ActionKind = iota
+	ActionSymlink
+	ActionRemove
+	ActionCommand
+	ActionMkdir
+)
+
+var actionNames = [...]string{
+	ActionWriteFile: "write",
+	ActionSymlink:   "symlink",
+	ActionRemove:    "remove",
+	ActionCommand:   "run",
+	ActionMkdir:     "mkdir",
+}
+
+func (k ActionKind) String() string {
+	if k < 0 || int(k) >= len(actionNames) {
+		return "unknown"
+	}
+	return actionNames[k]
+}
+
+// Action is a single side effect of an operation
+type Action struct {
+	Kind ActionKind
+	// Path of the written or removed file, of the created symlink
+	// or directory
+	Path string
+	// Mode of the written file or created directory
+	Mode os.FileMode
+	// Content of the written file
+	Content []byte
+	// Target of the symlink
+	Target string
+	// Command to run
+	Command Command
+	// IgnoreError continues with the next action when this one fails
+	IgnoreError bool
+}
+
+func (a Action) String() string {
+	switch a.Kind {
+	case ActionWriteFile:
+		return fmt.Sprintf("write %s (%v, %d bytes)", a.Path, a.Mode, len(a.Content))
+	case ActionSymlink:
+		return fmt.Sprintf("symlink %s -> %s", a.Path, a.Target)
+	case ActionRemove:
+		return "remove " + a.Path
+	case ActionCommand:
+		return "run " + a.Command.String()
+	case ActionMkdir:
+		return fmt.Sprintf("mkdir %s (%v)", a.Path, a.Mode)
+	}
+	return a.Kind.String()
+}
+
+// Plan lists side effects of an operation in the order they are applied.
+// Paths include ServiceConfig.RootDir, symlink targets do not.
+type Plan struct {
+	Op      Operation
+	Name    string
+	Backend string
+	Actions []Action
+
+	root string
+}
+
+// newPlan returns empty plan of op for the configured service
+func (c *ServiceConfig) newPlan(op Operation, backend string) *Plan {
+	return &Plan{Op: op, Name: c.Name, Backend: backend, root: c.RootDir}
+}
+
+func (p *Plan) writeFile(path string, mode os.FileMode, content []byte) {
+	p.Actions = append(p.Actions, Action{Kind: ActionWriteFile, Path: rootPath(p.root, path), Mode: mode, Content: content})
+}
+
+func (p *Plan) symlink(target, path string, ignoreError bool) {
+	p.Actions = append(p.Actions, Action{Kind: ActionSymlink, Path: rootPath(p.root, path), Target: target, IgnoreError: ignoreError})
+}
+
+func (p *Plan) remove(path string, ignoreError bool) {
+	p.Actions = append(p.Actions, Action{Kind: ActionRemove, Path: rootPath(p.root, path), IgnoreError: ignoreError})
+}
+
+func (p *Plan) mkdir(path string, mode os.FileMode) {
+	p.Actions = append(p.Actions, Action{Kind: ActionMkdir, Path: rootPath(p.root, path), Mode: mode})
+}
+
+func (p *Plan) command(ignoreError bool, command string, arguments ...string) {
+	p.Actions = append(p.Actions, Action{
+		Kind:        ActionCommand,
+		Command:     Command{Path: command, Args: arguments},
+		IgnoreError: ignoreError,
+	})
+}
+
+func (p *Plan) filter(kind ActionKind) []Action {
+	var actions []Action
+	for _, a := range p.Actions {
+		if a.Kind == kind {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// Files returns files the plan writes
+func (p *Plan) Files() []Action {
+	return p.filter(ActionWriteFile)
+}
+
+// Symlinks returns symlinks the plan creates
+func (p *Plan) Symlinks() []Action {
+	return p.filter(ActionSymlink)
+}
+
+// Removals returns files the plan removes
+func (p *Plan) Removals() []Action {
+	return p.filter(ActionRemove)
+}
+
+// Commands returns commands the plan runs
+func (p *Plan) Commands() []Command {
+	var commands []Command
+	for _, a := range p.filter(ActionCommand) {
+		commands = append(commands, a.Command)
+	}
+	return commands
+}
+
+func (p *Plan) String() string {
+	lines := []string{fmt.Sprintf("%s %s (%s)", p.Op, p.Name, p.Backend)}
+	for _, a := range p.Actions {
+		lines = append(lines, "  "+a.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// apply performs plan actions in order
+func (c *ServiceConfig) apply(ctx context.Context, p *Plan) error {
+	return c.applyWith(ctx, p, func(ctx context.Context, cmd Command) error {
+		_, err := c.execute(ctx, cmd)
+		return err
+	})
+}
+
+// applyWith applies p running commands with run. When escalating, files
+// are changed by commands too.
+func (c *ServiceConfig) applyWith(ctx context.Context, p *Plan, run func(context.Context, Command) error) error {
+	escalating := c.escalating()
+	for _, a := range p.Actions {
+		var err error
+		if escalating && a.Kind != ActionCommand {
+			for _, cmd := range fileCommands(a) {
+				if err = run(ctx, cmd); err != nil {
+					break
+				}
+			}
+		} else {
+			err = applyAction(ctx, a, run)
+		}
+		if err != nil && !a.IgnoreError {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyAction performs a in the filesystem of the process
+func applyAction(ctx context.Context, a Action, run func(context.Context, Command) error) error {
+	switch a.Kind {
+	case ActionWriteFile:
+		err := ioutil.WriteFile(a.Path, a.Content, a.Mode)
+		if err != nil {
+			return err
+		}
+		return os.Chmod(a.Path, a.Mode)
+	case ActionSymlink:
+		return os.Symlink(a.Target, a.Path)
+	case ActionRemove:
+		return os.Remove(a.Path)
+	case ActionCommand:
+		return run(ctx, a.Command)
+	case ActionMkdir:
+		return os.MkdirAll(a.Path, a.Mode)
+	}
+	return nil
+}
+
+// fileCommands returns commands performing file action a
+func fileCommands(a Action) []Command {
+	mode := fmt.Sprintf("%o", a.Mode.Perm())
+	switch a.Kind {
+	case ActionWriteFile:
+		content := a.Content
+		if content == nil {
+			content = []byte{}
+		}
+		return []Command{
+			{Path: "tee", Args: []string{a.Path}, Stdin: content},
+			{Path: "chmod", Args: []string{mode, a.Path}},
+		}
+	case ActionSymlink:
+		return []Command{{Path: "ln", Args: []string{"-s", a.Target, a.Path}}}
+	case ActionRemove:
+		return []Command{{Path: "rm", Args: []string{a.Path}}}
+	case ActionMkdir:
+		return []Command{{Path: "mkdir", Args: []string{"-p", "-m", mode, a.Path}}}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPlanRootDir(t *testing.T) {
+	cfg := ServiceConfig{Name: "app", RootDir: "/mnt"}
+	p := cfg.newPlan(OpInstall, "sysv")
+	p.mkdir("/etc/init.d", 0755)
+	p.writeFile("/etc/init.d/app", 0755, []byte("#!/bin/sh\n"))
+	p.symlink("../init.d/app", "/etc/rc2.d/S50app", false)
+	p.remove("/etc/rc0.d/K50app", true)
+	p.command(false, "update-rc.d", "app", "defaults")
+
+	// symlink targets stay relative to the root of the installed system
+	want := `install app (sysv)
+  mkdir /mnt/etc/init.d (-rwxr-xr-x)
+  write /mnt/etc/init.d/app (-rwxr-xr-x, 10 bytes)
+  symlink /mnt/etc/rc2.d/S50app -> ../init.d/app
+  remove /mnt/etc/rc0.d/K50app
+  run update-rc.d app defaults`
+	if got := p.String(); got != want {
+		t.Errorf("String() =\n%s\nwant\n%s", got, want)
+	}
+	if files := paths(p.Files()); !reflect.DeepEqual(files, []string{"/mnt/etc/init.d/app"}) {
+		t.Errorf("Files() = %q", files)
+	}
+	if commands := p.Commands(); len(commands) != 1 || commands[0].String() != "update-rc.d app defaults" {
+		t.Errorf("Commands() = %v", commands)
+	}
+}
+
+func TestFileCommands(t *testing.T) {
+	tests := []struct {
+		action Action
+		want   []string
+	}{
+		{Action{Kind: ActionWriteFile, Path: "/etc/init.d/app", Mode: 0755}, []string{"tee /etc/init.d/app", "chmod 755 /etc/init.d/app"}},
+		{Action{Kind: ActionSymlink, Path: "/etc/rc2.d/S50app", Target: "../init.d/app"}, []string{"ln -s ../init.d/app /etc/rc2.d/S50app"}},
+		{Action{Kind: ActionRemove, Path: "/etc/init.d/app"}, []string{"rm /etc/init.d/app"}},
+		{Action{Kind: ActionMkdir, Path: "/etc/init", Mode: 0755}, []string{"mkdir -p -m 755 /etc/init"}},
+		{Action{Kind: ActionCommand, Command: Command{Path: "true"}}, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, cmd := range fileCommands(tt.action) {
+			got = append(got, cmd.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("fileCommands(%v) = %q, want %q", tt.action, got, tt.want)
+		}
+	}
+	// tee writes empty files from stdin too
+	if cmds := fileCommands(Action{Kind: ActionWriteFile, Path: "/f"}); cmds[0].Stdin == nil {
+		t.Error("fileCommands() of empty file has no stdin")
+	}
+}
+
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	cfg := ServiceConfig{Name: "app", RootDir: dir}
+	p := cfg.newPlan(OpInstall, "sysv")
+	p.mkdir("/etc/init.d", 0755)
+	p.writeFile("/etc/init.d/app", 0750, []byte("#!/bin/sh\n"))
+	p.symlink("../init.d/app", "/etc/init.d/S50app", false)
+	p.remove("/etc/init.d/missing", true)
+	p.command(false, "update-rc.d", "app", "defaults")
+
+	var ran []string
+	err := cfg.applyWith(context.Background(), p, func(ctx context.Context, cmd Command) error {
+		ran = append(ran, cmd.String())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("applyWith() failed: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "etc/init.d/app")); err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf("written file = %v, %v", info, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "etc/init.d/S50app")); target != "../init.d/app" || err != nil {
+		t.Errorf("symlink = %q, %v", target, err)
+	}
+	if want := []string{"update-rc.d app defaults"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %q, want %q", ran, want)
+	}
+
+	// failing actions stop the plan unless their error is ignored
+	p = cfg.newPlan(OpRemove, "sysv")
+	p.remove("/etc/init.d/missing", false)
+	p.writeFile("/etc/init.d/after", 0644, nil)
+	if err := cfg.applyWith(context.Background(), p, nil); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("applyWith() returned %v, want %v", err, os.ErrNotExist)
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "etc/init.d/after")); err == nil {
+		t.Error("action after failed one was applied")
+	}
+}
@@ -0,0 +1,48 @@
+package supervisor
+
+import "fmt"
+
+// Escalation is the tool running commands with privileges the process
+// lacks, e.g. sudo configured with NOPASSWD rules for the service
+type Escalation string
+
+const (
+	EscalateSudo   Escalation = "sudo"
+	EscalateDoas   Escalation = "doas"
+	EscalatePkexec Escalation = "pkexec"
+)
+
+// command returns cmd run through the escalation tool. The tools reset
+// the environment, so Env is passed with env(1).
+func (e Escalation) command(cmd Command) (Command, error) {
+	var args []string
+	switch e {
+	case EscalateSudo, EscalateDoas:
+		// fail instead of prompting for a password
+		args = []string{"-n"}
+	case EscalatePkexec:
+		args = []string{"--disable-internal-agent"}
+	default:
+		return cmd, fmt.Errorf("%w: escalation %q", ErrNotSupported, e)
+	}
+	if len(cmd.Env) > 0 {
+		args = append(append(args, "env"), cmd.Env...)
+	}
+	args = append(append(args, cmd.Path), cmd.Args...)
+	return Command{Path: string(e), Args: args, Stdin: cmd.Stdin}, nil
+}
+
+// escalating reports whether commands and file changes go through
+// c.Escalate, which is the case when the process lacks privileges
+func (c *ServiceConfig) escalating() bool {
+	return c.Escalate != "" && !c.offline() && !hasPrivileges()
+}
+
+// privileged checks the process may manage system services by itself or
+// through c.Escalate
+func (c *ServiceConfig) privileged() (bool, error) {
+	if c.Escalate != "" || hasPrivileges() {
+		return true, nil
+	}
+	return false, ErrPermissionDenied
+}
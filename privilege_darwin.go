@@ -0,0 +1,8 @@
+package supervisor
+
+import "os"
+
+// hasPrivileges reports whether the process runs as root
+func hasPrivileges() bool {
+	return os.Geteuid() == 0
+}
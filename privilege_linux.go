@@ -0,0 +1,55 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// capabilities a process not run by root needs to write unit files and
+// scripts and to manage units, systemd checks CAP_SYS_ADMIN of bus clients
+const (
+	capDacOverride = 1
+	capSysAdmin    = 21
+)
+
+var (
+	privilegesOnce sync.Once
+	privileges     bool
+)
+
+// hasPrivileges reports whether the process runs as root or its effective
+// capabilities allow managing services. Root is accepted whatever its
+// capabilities, containers drop some of them and only systemd checks them.
+func hasPrivileges() bool {
+	privilegesOnce.Do(func() {
+		if os.Geteuid() == 0 {
+			privileges = true
+			return
+		}
+		caps, ok := effectiveCaps()
+		if !ok {
+			return
+		}
+		need := uint64(1)<<capDacOverride | uint64(1)<<capSysAdmin
+		privileges = caps&need == need
+	})
+	return privileges
+}
+
+// effectiveCaps reads CapEff of the process from /proc/self/status
+func effectiveCaps() (uint64, bool) {
+	status, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if strings.HasPrefix(line, "CapEff:") {
+			caps, err := strconv.ParseUint(strings.TrimSpace(line[len("CapEff:"):]), 16, 64)
+			return caps, err == nil
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,57 @@
+package supervisor
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEscalationCommand(t *testing.T) {
+	systemctl := Command{Path: "systemctl", Args: []string{"restart", "app.service"}}
+	withEnv := Command{Path: "initctl", Args: []string{"start", "app"}, Env: []string{"LANG=C", "A=b c"}}
+	tee := Command{Path: "tee", Args: []string{"/etc/init.d/app"}, Stdin: []byte("#!/bin/sh\n")}
+	tests := []struct {
+		escalate Escalation
+		cmd      Command
+		want     []string
+	}{
+		{EscalateSudo, systemctl, []string{"sudo", "-n", "systemctl", "restart", "app.service"}},
+		{EscalateDoas, systemctl, []string{"doas", "-n", "systemctl", "restart", "app.service"}},
+		{EscalatePkexec, systemctl, []string{"pkexec", "--disable-internal-agent", "systemctl", "restart", "app.service"}},
+		// the tools reset the environment
+		{EscalateSudo, withEnv, []string{"sudo", "-n", "env", "LANG=C", "A=b c", "initctl", "start", "app"}},
+		{EscalatePkexec, withEnv, []string{"pkexec", "--disable-internal-agent", "env", "LANG=C", "A=b c", "initctl", "start", "app"}},
+		{EscalateDoas, tee, []string{"doas", "-n", "tee", "/etc/init.d/app"}},
+	}
+	for _, tt := range tests {
+		got, err := tt.escalate.command(tt.cmd)
+		if err != nil {
+			t.Errorf("command(%v) through %s failed: %v", tt.cmd, tt.escalate, err)
+			continue
+		}
+		if argv := append([]string{got.Path}, got.Args...); !reflect.DeepEqual(argv, tt.want) {
+			t.Errorf("command(%v) through %s = %q, want %q", tt.cmd, tt.escalate, argv, tt.want)
+		}
+		if got.Env != nil || !reflect.DeepEqual(got.Stdin, tt.cmd.Stdin) {
+			t.Errorf("command(%v) through %s has env %q and stdin %q", tt.cmd, tt.escalate, got.Env, got.Stdin)
+		}
+	}
+
+	if _, err := Escalation("su").command(systemctl); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("command() through su returned %v, want %v", err, ErrNotSupported)
+	}
+}
+
+func TestEscalating(t *testing.T) {
+	// offline installs write files of the mounted root directly
+	cfg := ServiceConfig{Escalate: EscalateSudo, RootDir: "/mnt"}
+	if cfg.escalating() {
+		t.Error("offline install escalates")
+	}
+	if (&ServiceConfig{}).escalating() {
+		t.Error("escalating without Escalate")
+	}
+	if ok, err := (&ServiceConfig{Escalate: EscalateSudo}).privileged(); !ok || err != nil {
+		t.Errorf("privileged() with Escalate = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,72 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, which is 100 on every supported architecture
+const clockTicks = 100
+
+// processStartTime returns start time of pid, zero time if unknown
+func processStartTime(pid int) time.Time {
+	if pid <= 0 {
+		return time.Time{}
+	}
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return time.Time{}
+	}
+	// comm may contain spaces, fields are counted after its closing paren
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return time.Time{}
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	// starttime is field 22, the 20th after comm
+	if len(fields) < 20 {
+		return time.Time{}
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	boot, err := bootTime()
+	if err != nil {
+		return time.Time{}
+	}
+	return boot.Add(time.Duration(ticks) * time.Second / clockTicks)
+}
+
+// bootTime reads system boot time from /proc/stat
+func bootTime() (time.Time, error) {
+	stat, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(stat), "\n") {
+		if strings.HasPrefix(line, "btime ") {
+			sec, err := strconv.ParseInt(strings.TrimSpace(line[len("btime "):]), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(sec, 0), nil
+		}
+	}
+	return time.Time{}, ErrOSNotSupported
+}
+
+// readPidFile returns pid stored in file, -1 if it is missing or invalid
+func readPidFile(file string) int {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return -1
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 {
+		return -1
+	}
+	return pid
+}
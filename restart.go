@@ -0,0 +1,287 @@
+package supervisor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RestartMode selects when the service is restarted after it exits
+type RestartMode string
+
+const (
+	RestartNever     RestartMode = "never"
+	RestartOnFailure RestartMode = "on-failure"
+	// RestartAlways restarts the service after any exit. procd instances
+	// are restarted after any exit with RestartOnFailure too.
+	RestartAlways RestartMode = "always"
+)
+
+// LimitAction is taken once the service exceeded MaxAttempts restarts
+type LimitAction string
+
+const (
+	// LimitStop gives the service up
+	LimitStop LimitAction = "stop"
+	// LimitReboot reboots the system, not supported by upstart, launchd
+	// and procd instances
+	LimitReboot LimitAction = "reboot"
+)
+
+// defaultRestartWindow is the Window of MaxAttempts left zero
+const defaultRestartWindow = 5 * time.Minute
+
+// RestartPolicy controls restarting of the exited service. The zero
+// value keeps the default of the backend: systemd restarts on failure
+// after 10 seconds, upstart, procd and launchd always restart and init
+// scripts do not restart.
+type RestartPolicy struct {
+	// Mode is RestartOnFailure when other fields are set
+	Mode RestartMode
+	// Delay before restarting, zero keeps the default of the backend
+	Delay time.Duration
+	// MaxAttempts restarts within Window before OnLimit is taken, zero
+	// restarts forever. launchd cannot limit restarts.
+	MaxAttempts int
+	// Window of MaxAttempts, 5 minutes by default
+	Window time.Duration
+	// OnLimit is LimitStop by default
+	OnLimit LimitAction
+}
+
+// isZero reports whether p keeps the default of the backend
+func (p RestartPolicy) isZero() bool {
+	return p == RestartPolicy{}
+}
+
+// mode returns Mode with its default
+func (p RestartPolicy) mode() (RestartMode, error) {
+	switch p.Mode {
+	case "":
+		return RestartOnFailure, nil
+	case RestartNever, RestartOnFailure, RestartAlways:
+		return p.Mode, nil
+	}
+	return "", fmt.Errorf("unknown restart mode %q", p.Mode)
+}
+
+// window returns Window with its default
+func (p RestartPolicy) window() time.Duration {
+	if p.Window == 0 {
+		return defaultRestartWindow
+	}
+	return p.Window
+}
+
+// limit reports whether restarts are limited
+func (p RestartPolicy) limit() bool {
+	return p.MaxAttempts > 0
+}
+
+// rebootOnLimit reports whether the system is rebooted on limit
+func (p RestartPolicy) rebootOnLimit() bool {
+	return p.limit() && p.OnLimit == LimitReboot
+}
+
+// check rejects Mode and OnLimit unknown or, when canReboot is false,
+// LimitReboot
+func (p RestartPolicy) check(backend string, canReboot bool) error {
+	if _, err := p.mode(); err != nil {
+		return err
+	}
+	switch p.OnLimit {
+	case "", LimitStop:
+	case LimitReboot:
+		if !canReboot {
+			return fmt.Errorf("%w: reboot on restart limit on %s", ErrNotSupported, backend)
+		}
+	default:
+		return fmt.Errorf("unknown restart limit action %q", p.OnLimit)
+	}
+	return nil
+}
+
+// seconds returns d rounded up to whole seconds
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// systemdRestart returns values of Restart= for modes
+var systemdRestart = map[RestartMode]string{
+	RestartNever:     "no",
+	RestartOnFailure: "on-failure",
+	RestartAlways:    "always",
+}
+
+// startLimit returns [Unit] directives limiting restarts
+func (p RestartPolicy) startLimit() string {
+	if !p.limit() {
+		return ""
+	}
+	lines := []string{
+		"StartLimitIntervalSec=" + strconv.Itoa(seconds(p.window())),
+		"StartLimitBurst=" + strconv.Itoa(p.MaxAttempts),
+	}
+	if p.rebootOnLimit() {
+		lines = append(lines, "StartLimitAction=reboot")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// upstart returns respawn stanzas and the restart delay in seconds run
+// by the post-stop stanza, the zero policy keeps bare respawn
+func (p RestartPolicy) upstart() (string, int) {
+	mode, _ := p.mode()
+	if p.isZero() {
+		mode = RestartAlways
+	}
+	if mode == RestartNever {
+		return "", 0
+	}
+	lines := []string{"respawn"}
+	if p.limit() {
+		lines = append(lines, fmt.Sprintf("respawn limit %d %d", p.MaxAttempts, seconds(p.window())))
+	} else if !p.isZero() {
+		lines = append(lines, "respawn limit unlimited")
+	}
+	if mode == RestartOnFailure {
+		lines = append(lines, "normal exit 0")
+	}
+	return strings.Join(lines, "\n"), seconds(p.Delay)
+}
+
+// procd returns arguments of procd_set_param respawn: threshold, timeout
+// and retry with procd defaults for unset ones
+func (p RestartPolicy) procd() string {
+	if p.isZero() {
+		return ""
+	}
+	threshold, timeout, retry := 3600, 5, 5
+	if p.Window != 0 || p.limit() {
+		threshold = seconds(p.window())
+	}
+	if p.Delay > 0 {
+		timeout = seconds(p.Delay)
+	}
+	if p.limit() {
+		retry = p.MaxAttempts
+	} else if p.Mode != "" {
+		// restart forever
+		retry = 0
+	}
+	return fmt.Sprintf("%d %d %d", threshold, timeout, retry)
+}
+
+// respawnLoop returns shell script running command with output appended
+// to stdout and stderr logs and restarting it according to the policy.
+// It contains no single quote so it can be quoted by them. The stop
+// signal stops the loop once command exits, forwardedSignals are passed
+// to command. SIGINT and SIGQUIT reach command when the loop is run by
+// bash.
+func (p RestartPolicy) respawnLoop(command, stdout, stderr string, stop stopping) string {
+	mode, _ := p.mode()
+	delay := p.Delay
+	if delay == 0 {
+		delay = 10 * time.Second
+	}
+	lines := []string{stop.trap()}
+	for _, sig := range forwardedSignals {
+		if sig != stop.signal {
+			lines = append(lines, `trap "kill -`+sig+` \$child 2> /dev/null" `+sig)
+		}
+	}
+	lines = append(lines,
+		"attempts=0",
+		"since=$(date +%s)",
+		"while :; do",
+		"    "+stop.resetIgnored(command)+" >> "+stdout+" 2>> "+stderr+" &",
+		"    child=$!",
+		"    wait $child",
+		"    status=$?",
+		// wait returns early when a forwarded signal is trapped
+		"    while kill -0 $child 2> /dev/null; do",
+		"        wait $child",
+		"        status=$?",
+		"    done",
+	)
+	if mode == RestartOnFailure {
+		lines = append(lines, "    [ $status -eq 0 ] && exit 0")
+	}
+	if p.limit() {
+		giveUp := "exit $status"
+		if p.rebootOnLimit() {
+			giveUp = "reboot"
+		}
+		window := strconv.Itoa(seconds(p.window()))
+		attempts := strconv.Itoa(p.MaxAttempts)
+		lines = append(lines,
+			"    now=$(date +%s)",
+			"    if [ $((now - since)) -ge "+window+" ]; then",
+			"        since=$now",
+			"        attempts=0",
+			"    fi",
+			"    attempts=$((attempts + 1))",
+			"    if [ $attempts -gt "+attempts+" ]; then",
+			`        echo "$(date) restarted `+attempts+` times in `+window+` seconds, giving up" >> `+stderr,
+			"        "+giveUp,
+			"    fi",
+		)
+	}
+	lines = append(lines,
+		"    sleep "+strconv.Itoa(seconds(delay)),
+		"done",
+	)
+	return strings.Join(lines, "\n")
+}
+
+// forwardedSignals are passed by the respawn loop to the service, e.g.
+// to reload it, as Signal and Reload deliver them to the loop
+var forwardedSignals = []string{"HUP", "USR1", "USR2"}
+
+// respawns reports whether init scripts run the respawn loop
+func (p RestartPolicy) respawns() bool {
+	mode, _ := p.mode()
+	return !p.isZero() && mode != RestartNever
+}
+
+// parseRespawnLoop parses script assigning the loop returned by
+// respawnLoop to variable respawn
+func (p *RestartPolicy) parseRespawnLoop(script string) {
+	var loop bool
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "respawn='") {
+			loop = true
+		}
+		if loop {
+			p.parseRespawnLine(line)
+		}
+		if line == "done'" {
+			break
+		}
+	}
+}
+
+func (p *RestartPolicy) parseRespawnLine(line string) {
+	switch {
+	case line == "while :; do":
+		if p.Mode == "" {
+			p.Mode = RestartAlways
+		}
+	case line == "[ $status -eq 0 ] && exit 0":
+		p.Mode = RestartOnFailure
+	case strings.HasPrefix(line, "if [ $((now - since)) -ge "):
+		if v, err := strconv.Atoi(strings.Fields(line)[6]); err == nil {
+			p.Window = time.Duration(v) * time.Second
+		}
+	case strings.HasPrefix(line, "if [ $attempts -gt "):
+		p.MaxAttempts, _ = strconv.Atoi(strings.Fields(line)[4])
+	case line == "reboot":
+		p.OnLimit = LimitReboot
+	case strings.HasPrefix(line, "sleep "):
+		if v, err := strconv.Atoi(strings.TrimPrefix(line, "sleep ")); err == nil {
+			p.Delay = time.Duration(v) * time.Second
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestartPolicyCheck(t *testing.T) {
+	tests := []struct {
+		policy    RestartPolicy
+		canReboot bool
+		ok        bool
+	}{
+		{RestartPolicy{}, false, true},
+		{RestartPolicy{Mode: RestartAlways, OnLimit: LimitStop}, false, true},
+		{RestartPolicy{Mode: "sometimes"}, true, false},
+		{RestartPolicy{MaxAttempts: 3, OnLimit: LimitReboot}, true, true},
+		{RestartPolicy{MaxAttempts: 3, OnLimit: LimitReboot}, false, false},
+		{RestartPolicy{OnLimit: "panic"}, true, false},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.check("test", tt.canReboot); (err == nil) != tt.ok {
+			t.Errorf("check(%+v, %v) returned %v", tt.policy, tt.canReboot, err)
+		}
+	}
+}
+
+func TestRestartFragments(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       RestartPolicy
+		startLimit   string
+		upstart      string
+		upstartDelay int
+		procd        string
+	}{
+		{"zero", RestartPolicy{}, "", "respawn", 0, ""},
+		{"never", RestartPolicy{Mode: RestartNever}, "", "", 0, "3600 5 0"},
+		{"always", RestartPolicy{Mode: RestartAlways, Delay: 3 * time.Second}, "",
+			"respawn\nrespawn limit unlimited", 3, "3600 3 0"},
+		{"on failure", RestartPolicy{Mode: RestartOnFailure}, "",
+			"respawn\nrespawn limit unlimited\nnormal exit 0", 0, "3600 5 0"},
+		{"limited", RestartPolicy{MaxAttempts: 3, Window: time.Minute, Delay: 1500 * time.Millisecond},
+			"StartLimitIntervalSec=60\nStartLimitBurst=3",
+			"respawn\nrespawn limit 3 60\nnormal exit 0", 2, "60 2 3"},
+		{"limited default window", RestartPolicy{Mode: RestartAlways, MaxAttempts: 5, OnLimit: LimitReboot},
+			"StartLimitIntervalSec=300\nStartLimitBurst=5\nStartLimitAction=reboot",
+			"respawn\nrespawn limit 5 300", 0, "300 5 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.startLimit(); got != tt.startLimit {
+				t.Errorf("startLimit() = %q, want %q", got, tt.startLimit)
+			}
+			if got, delay := tt.policy.upstart(); got != tt.upstart || delay != tt.upstartDelay {
+				t.Errorf("upstart() = %q, %d, want %q, %d", got, delay, tt.upstart, tt.upstartDelay)
+			}
+			if got := tt.policy.procd(); got != tt.procd {
+				t.Errorf("procd() = %q, want %q", got, tt.procd)
+			}
+		})
+	}
+}
+
+func TestParseRespawnLoop(t *testing.T) {
+	stop := stopping{signal: "TERM", timeout: defaultStopTimeout, mode: KillProcess}
+	tests := []struct {
+		policy RestartPolicy
+		want   RestartPolicy
+	}{
+		{RestartPolicy{Mode: RestartAlways}, RestartPolicy{Mode: RestartAlways, Delay: 10 * time.Second}},
+		{RestartPolicy{Delay: 2 * time.Second}, RestartPolicy{Mode: RestartOnFailure, Delay: 2 * time.Second}},
+		{
+			RestartPolicy{Mode: RestartAlways, Delay: time.Second, MaxAttempts: 3, Window: time.Minute, OnLimit: LimitReboot},
+			RestartPolicy{Mode: RestartAlways, Delay: time.Second, MaxAttempts: 3, Window: time.Minute, OnLimit: LimitReboot},
+		},
+	}
+	for _, tt := range tests {
+		script := "#!/bin/sh\nrespawn='" + tt.policy.respawnLoop("/usr/bin/app", "/var/log/app.log", "/var/log/app.err", stop) + "'\nsleep 5\n"
+		var got RestartPolicy
+		got.parseRespawnLoop(script)
+		if got != tt.want {
+			t.Errorf("parseRespawnLoop() of %+v = %+v, want %+v", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestRespawnLoopRun(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	stop := stopping{signal: "TERM", timeout: defaultStopTimeout, mode: KillProcess}
+	dir := t.TempDir()
+	stdout, stderr := filepath.Join(dir, "out.log"), filepath.Join(dir, "err.log")
+	tests := []struct {
+		name   string
+		policy RestartPolicy
+		// command ends the script of the service
+		command string
+		status  int
+		runs    int
+	}{
+		// a clean exit is not restarted on failure
+		{"on failure", RestartPolicy{Mode: RestartOnFailure}, "true", 0, 1},
+		{"limited", RestartPolicy{Mode: RestartAlways, Delay: time.Millisecond, MaxAttempts: 1}, "false", 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := filepath.Join(dir, "app")
+			if err := ioutil.WriteFile(app, []byte("echo run\n"+tt.command+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			loop := tt.policy.respawnLoop("sh "+app, stdout, stderr, stop)
+			err := exec.Command("sh", "-c", loop).Run()
+			status := 0
+			if exit, ok := err.(*exec.ExitError); ok {
+				status = exit.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.status {
+				t.Errorf("loop exited with %d, want %d", status, tt.status)
+			}
+			out, _ := ioutil.ReadFile(stdout)
+			if runs := strings.Count(string(out), "run\n"); runs != tt.runs {
+				t.Errorf("command ran %d times, want %d", runs, tt.runs)
+			}
+			ioutil.WriteFile(stdout, nil, 0644)
+		})
+	}
+	if log, _ := ioutil.ReadFile(stderr); !strings.Contains(string(log), "restarted 1 times in 300 seconds, giving up") {
+		t.Errorf("loop did not log giving up: %q", log)
+	}
+}
@@ -1,8 +1,9 @@
 package supervisor
 
 import (
-	"errors"
+	"context"
 	"os"
+	"syscall"
 )
 
 const (
@@ -11,6 +12,9 @@ const (
 	installFailed = "Failed to install"
 	stopFailed    = "Failed to stop"
 	removeFailed  = "Failed to remove"
+	reloadFailed  = "Failed to reload"
+	enableFailed  = "Failed to enable"
+	disableFailed = "Failed to disable"
 
 	undefined = "undefined"
 	running   = "running"
@@ -19,38 +23,93 @@ const (
 	installed = "installed"
 	started   = "started"
 	restarted = "restarted"
-)
-
-var (
-	errNotInstalled     = errors.New("not installed")
-	errNotRunning       = errors.New("service is not running")
-	errOSNotSupported   = errors.New("OS not supported")
-	errPermissionDenied = errors.New("permission denied")
-	errAlreadyInstalled = errors.New("already installed")
+	reloaded  = "reloaded"
+	enabled   = "enabled"
+	disabled  = "disabled"
 )
 
 // Service is supervised service
+//
+// Methods return *OpError, which wraps the sentinel errors.
+// Context methods kill commands run by the backend once ctx is done
+// and wrap *TimeoutError when the deadline is exceeded
 type Service interface {
 	Status() (string, error)
+	StatusContext(ctx context.Context) (string, error)
+	Inspect() (StatusInfo, error)
+	InspectContext(ctx context.Context) (StatusInfo, error)
 	Restart() (string, error)
+	RestartContext(ctx context.Context) (string, error)
 	Start() (string, error)
+	StartContext(ctx context.Context) (string, error)
 	Stop() (string, error)
+	StopContext(ctx context.Context) (string, error)
+	// Reload asks the service to reload its configuration without
+	// restarting it, by the Reload hook or SIGHUP by default
+	Reload() (string, error)
+	ReloadContext(ctx context.Context) (string, error)
+	// Signal delivers sig to the main process of the service
+	Signal(sig syscall.Signal) error
+	SignalContext(ctx context.Context, sig syscall.Signal) error
 	UpdateEnviron(env map[string]string) (string, error)
 	Install(args ...string) (string, error)
+	InstallContext(ctx context.Context, args ...string) (string, error)
 	Remove() (string, error)
+	RemoveContext(ctx context.Context) (string, error)
+	// Enable starts the installed service at boot. Install enables it
+	// already except procd scripts of services other than the agent,
+	// which are installed disabled.
+	Enable() (string, error)
+	EnableContext(ctx context.Context) (string, error)
+	// Disable keeps the installed service from starting at boot
+	Disable() (string, error)
+	DisableContext(ctx context.Context) (string, error)
+	// IsEnabled reports whether the service starts at boot
+	IsEnabled() (bool, error)
+	IsEnabledContext(ctx context.Context) (bool, error)
+	Plan(op Operation, args ...string) (*Plan, error)
 	PID() (int, error)
 	IsInstalled() bool
 	ServiceName() string
 }
 
 // NewService returns new supervised service
+//
+// NewService is kept for compatibility, use New to set other options
 func NewService(name, cmd, description, workingDir, logFile string, dependencies []string, environ map[string]string) Service {
-	return newService(name, cmd, description, workingDir, logFile, dependencies, environ)
+	return mustService(ServiceConfig{
+		Name:         name,
+		Cmd:          cmd,
+		Description:  description,
+		WorkingDir:   workingDir,
+		LogFile:      logFile,
+		Dependencies: dependencies,
+		Environ:      environ,
+	})
+}
+
+// New returns new supervised service described by cfg. The backend is
+// cfg.Backend, SUPERVISOR_BACKEND or the first one detected on the host.
+func New(cfg ServiceConfig) (Service, error) {
+	return newService(cfg)
 }
 
 // GetSimple returns supervised instance
 func GetSimple(name string) Service {
-	return getService(name)
+	return mustService(ServiceConfig{Name: name})
+}
+
+// mustService returns service of the forced backend, falling back to
+// the detected one when it is unknown
+func mustService(cfg ServiceConfig) Service {
+	if s, err := newService(cfg); err == nil {
+		return s
+	}
+	cfg.Backend = ""
+	if b, ok := detectBackend(); ok {
+		return b.factory(cfg.withDefaults())
+	}
+	return nil
 }
 
 func Interactive() bool {
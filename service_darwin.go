@@ -1,6 +1,11 @@
 package supervisor
 
 import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/user"
@@ -8,72 +13,100 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
 )
 
+const launchdBackend = "launchd"
+
 // darwin - standard record (struct) for darwin version of daemon package
 type darwin struct {
-	name         string
-	cmd          string
-	description  string
-	dependencies []string
-	workingDir   string
-	restart      string
-	restartSec   string
-	logFile      string
-	envs         map[string]string
+	cfg ServiceConfig
 }
 
-func newService(name, cmd, description, workingDir, logFile string, dependencies []string, envs map[string]string) Service {
-	return &darwin{name: name, cmd: cmd, description: description, workingDir: workingDir, logFile: logFile, dependencies: dependencies, envs: envs}
+func init() {
+	RegisterBackend(launchdBackend, newLaunchdService, func() (bool, string) {
+		return true, "darwin"
+	})
 }
 
-func getService(name string) Service {
-	return &darwin{name: name}
+func newLaunchdService(cfg ServiceConfig) Service {
+	return &darwin{cfg: cfg}
 }
 
-// Standard service path for system daemons
+// Standard service path for system daemons and user agents. Without
+// scope agents of the current user are used when it can be looked up.
 func (d *darwin) servicePath() string {
 	u, err := user.Current()
-	if err != nil {
-		return "/Library/LaunchDaemons/" + d.ServiceName()
+	switch {
+	case d.cfg.Scope == ScopeSystem:
+	case d.cfg.Scope == ScopeUser && err != nil:
+		home, _ := os.UserHomeDir()
+		return home + "/Library/LaunchAgents/" + d.ServiceName()
+	case err == nil:
+		return u.HomeDir + "/Library/LaunchAgents/" + d.ServiceName()
+	}
+	return "/Library/LaunchDaemons/" + d.ServiceName()
+}
+
+// domain is the launchd domain of the service path, system for daemons
+// and the GUI session of the user for agents
+func (d *darwin) domain() string {
+	if strings.HasPrefix(d.servicePath(), "/Library/LaunchDaemons/") {
+		return "system"
 	}
-	return u.HomeDir + "/Library/LaunchAgents/" + d.ServiceName()
+	return "gui/" + strconv.Itoa(os.Getuid())
+}
+
+func (d *darwin) supportsScope(scope Scope) bool {
+	return scope == ScopeUser
+}
+
+func (d *darwin) serviceDir() (string, string) {
+	return filepath.Dir(d.servicePath()), ".plist"
 }
 
 func (d *darwin) ServiceName() string {
-	return d.name + ".plist"
+	return d.cfg.Name + ".plist"
+}
+
+// opError annotates err with the operation and service
+func (d *darwin) opError(op string, err error) error {
+	return newOpError(op, d.cfg.Name, launchdBackend, err)
 }
 
 func (d *darwin) UpdateEnviron(env map[string]string) (string, error) {
-	d.Remove()
-	if status, err := d.Install(); err != nil {
-		return status, err
+	p, err := d.plan(OpUpdateEnviron, nil)
+	if err != nil {
+		return updateFailed, d.opError("update", err)
+	}
+	if err := d.cfg.apply(context.Background(), p); err != nil {
+		return updateFailed, d.opError("update", err)
 	}
 	return "updated", nil
 }
 
 // Is a service installed
 func (d *darwin) IsInstalled() bool {
-	if _, err := os.Stat(d.servicePath()); err == nil {
+	if _, err := os.Stat(d.cfg.path(d.servicePath())); err == nil {
 		return true
 	}
 	return false
 }
 
 func (d *darwin) PID() (int, error) {
-	if pid, running := d.checkRunning(); running {
+	if pid, running := d.checkRunning(context.Background()); running {
 		return strconv.Atoi(pid)
 	}
 	return -1, nil
 }
 
 // Check service is running
-func (d *darwin) checkRunning() (string, bool) {
-	output, err := exec.Command("launchctl", "list", d.name).Output()
+func (d *darwin) checkRunning(ctx context.Context) (string, bool) {
+	output, err := d.cfg.output(ctx, "launchctl", "list", d.cfg.Name)
 	if err == nil {
-		if matched, err := regexp.MatchString(d.name, string(output)); err == nil && matched {
+		if matched, err := regexp.MatchString(d.cfg.Name, string(output)); err == nil && matched {
 			reg := regexp.MustCompile("PID\" = ([0-9]+);")
 			data := reg.FindStringSubmatch(string(output))
 			if len(data) > 1 {
@@ -87,116 +120,538 @@ func (d *darwin) checkRunning() (string, bool) {
 
 // Install the service
 func (d *darwin) Install(args ...string) (string, error) {
-	srvPath := d.servicePath()
-	if d.IsInstalled() {
-		return installFailed, errAlreadyInstalled
+	return d.InstallContext(context.Background(), args...)
+}
+
+// InstallContext installs the service, ctx bounds file changes made with
+// commands run through Escalate
+func (d *darwin) InstallContext(ctx context.Context, args ...string) (string, error) {
+	p, err := d.plan(OpInstall, args)
+	if err != nil {
+		return installFailed, d.opError("install", err)
+	}
+	if err := d.cfg.apply(ctx, p); err != nil {
+		return installFailed, d.opError("install", err)
 	}
 
-	file, err := os.Create(srvPath)
+	return installed, nil
+}
+
+// Remove the service
+func (d *darwin) Remove() (string, error) {
+	return d.RemoveContext(context.Background())
+}
+
+// RemoveContext removes the service
+func (d *darwin) RemoveContext(ctx context.Context) (string, error) {
+	p, err := d.plan(OpRemove, nil)
+	if err != nil {
+		return "", d.opError("remove", err)
+	}
+	if err := d.cfg.apply(ctx, p); err != nil {
+		return "", d.opError("remove", err)
+	}
+	return removed, nil
+}
+
+// Enable the service at load
+func (d *darwin) Enable() (string, error) {
+	return d.EnableContext(context.Background())
+}
+
+// EnableContext enables the service in its launchd domain
+func (d *darwin) EnableContext(ctx context.Context) (string, error) {
+	if err := d.switchBoot(ctx, OpEnable); err != nil {
+		return "", err
+	}
+	return enabled, nil
+}
+
+// Disable the service at load
+func (d *darwin) Disable() (string, error) {
+	return d.DisableContext(context.Background())
+}
+
+// DisableContext disables the service in its launchd domain, a loaded
+// service keeps running
+func (d *darwin) DisableContext(ctx context.Context) (string, error) {
+	if err := d.switchBoot(ctx, OpDisable); err != nil {
+		return "", err
+	}
+	return disabled, nil
+}
+
+// switchBoot applies OpEnable or OpDisable
+func (d *darwin) switchBoot(ctx context.Context, op Operation) error {
+	p, err := d.plan(op, nil)
 	if err != nil {
-		return installFailed, err
+		return d.opError(string(op), err)
 	}
-	defer file.Close()
+	return d.opError(string(op), d.cfg.apply(ctx, p))
+}
+
+func (d *darwin) IsEnabled() (bool, error) {
+	return d.IsEnabledContext(context.Background())
+}
 
+// IsEnabledContext looks the service up in disabled services of its
+// launchd domain
+func (d *darwin) IsEnabledContext(ctx context.Context) (bool, error) {
+	if !d.IsInstalled() {
+		return false, d.opError("status", ErrNotInstalled)
+	}
+	out, err := d.cfg.output(ctx, "launchctl", "print-disabled", d.domain())
+	if err != nil {
+		return false, d.opError("status", err)
+	}
+	return !launchdDisabled(out, d.cfg.Name), nil
+}
+
+// launchdDisabled reports whether label is disabled in output of
+// launchctl print-disabled, older versions print true for disabled ones
+func launchdDisabled(out []byte, label string) bool {
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 3 && f[0] == strconv.Quote(label) && f[1] == "=>" {
+			return f[2] == "disabled" || f[2] == "true"
+		}
+	}
+	return false
+}
+
+// Plan returns side effects of op without applying them
+func (d *darwin) Plan(op Operation, args ...string) (*Plan, error) {
+	p, err := d.plan(op, args)
+	return p, d.opError(string(op), err)
+}
+
+func (d *darwin) plan(op Operation, args []string) (*Plan, error) {
+	p := d.cfg.newPlan(op, launchdBackend)
+	switch op {
+	case OpInstall:
+		if d.IsInstalled() {
+			return nil, ErrAlreadyInstalled
+		}
+		if err := d.cfg.planAccount(p, noAccountTools); err != nil {
+			return nil, err
+		}
+	case OpRemove:
+		if !d.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+	case OpUpdateEnviron:
+	case OpEnable, OpDisable:
+		if !d.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		if d.cfg.offline() {
+			// launchd keeps the switch in its own database
+			return nil, ErrNotSupported
+		}
+		p.command(false, "launchctl", string(op), d.domain()+"/"+d.cfg.Name)
+		return p, nil
+	case opReconcile:
+		// launchd reads the plist on load, restart reloads it
+		plist, err := d.render(args)
+		if err != nil {
+			return nil, err
+		}
+		p.writeFile(d.servicePath(), 0644, plist)
+		return p, nil
+	default:
+		return nil, ErrNotSupported
+	}
+
+	if op != OpInstall && d.IsInstalled() {
+		if !d.cfg.offline() {
+			p.command(true, "launchctl", "remove", d.servicePath())
+		}
+		p.remove(d.servicePath(), op == OpUpdateEnviron)
+	}
+	if op != OpRemove {
+		plist, err := d.render(args)
+		if err != nil {
+			return nil, err
+		}
+		p.writeFile(d.servicePath(), 0644, plist)
+	}
+	return p, nil
+}
+
+// render returns property list content
+func (d *darwin) render(args []string) ([]byte, error) {
 	templ, err := template.New("propertyList").Parse(propertyList)
 	if err != nil {
-		return installFailed, err
+		return nil, err
+	}
+	if err := d.cfg.systemdOnly(launchdBackend); err != nil {
+		return nil, err
+	}
+	if err := d.cfg.RestartPolicy.check(launchdBackend, false); err != nil {
+		return nil, err
+	}
+	if d.cfg.RestartPolicy.limit() {
+		return nil, fmt.Errorf("%w: restart limit on %s", ErrNotSupported, launchdBackend)
+	}
+	// launchd runs the program only
+	if !d.cfg.Hooks.isZero() {
+		return nil, fmt.Errorf("%w: hooks on %s", ErrNotSupported, launchdBackend)
+	}
+	// launchd sends SIGTERM and SIGKILL after ExitTimeOut
+	if err := d.cfg.fixedStopSignal(launchdBackend); err != nil {
+		return nil, err
+	}
+	if _, _, err := d.cfg.stopping(); err != nil {
+		return nil, err
 	}
-	cmd := strings.Split(d.cmd, " ")
+	mode, _ := d.cfg.RestartPolicy.mode()
+	if d.cfg.RestartPolicy.isZero() {
+		mode = RestartAlways
+	}
+	args = d.cfg.args(args)
+	name := d.cfg.Cmd
+	cmd := strings.Split(d.cfg.Cmd, " ")
 	if len(cmd) > 1 {
-		d.cmd = cmd[0]
+		name = cmd[0]
 		args = append(cmd[1:], args...)
 	}
-	if filepath.Base(d.cmd) == d.cmd { //check IsAbs
-		path, err := exec.LookPath(d.cmd)
+	if filepath.Base(name) == name { //check IsAbs
+		path, err := exec.LookPath(name)
 		if err == nil {
-			d.cmd = path
+			name = path
 		}
 	}
+	var buf bytes.Buffer
 	if err := templ.Execute(
-		file,
+		&buf,
 		&struct {
-			Name, Cmd           string
+			Name, Cmd, Marker   string
+			User, Group         string
 			WorkingDir, LogFile string
 			Args                []string
 			Envs                map[string]string
+			Limits              map[string]uint64
+			KeepAlive           RestartMode
+			Throttle            int
+			ExitTimeOut         int
+			AbandonGroup        bool
 		}{
-			Name: d.name, Cmd: d.cmd,
+			Name: d.cfg.Name, Cmd: name,
+			User: d.cfg.User, Group: d.cfg.Group,
+			Marker:     d.cfg.marker(d.servicePath()).comment("<!-- ", " -->"),
 			Args:       args,
-			WorkingDir: d.workingDir, LogFile: d.logFile,
-			Envs: d.envs,
+			WorkingDir: d.cfg.WorkingDir, LogFile: d.cfg.LogFile,
+			Envs:      d.cfg.Environ,
+			Limits:    d.cfg.Limits.launchd(),
+			KeepAlive: mode,
+			Throttle:  seconds(d.cfg.RestartPolicy.Delay),
+			// the process group is killed unless abandoned
+			ExitTimeOut:  seconds(d.cfg.StopTimeout),
+			AbandonGroup: d.cfg.KillMode == KillProcess,
 		},
 	); err != nil {
-		return installFailed, err
+		return nil, err
 	}
-
-	return installed, nil
-}
-
-// Remove the service
-func (d *darwin) Remove() (string, error) {
-	if !d.IsInstalled() {
-		return "", errNotInstalled
-	}
-
-	run("launchctl", "remove", d.servicePath())
-
-	if err := os.Remove(d.servicePath()); err != nil {
-		return "", err
-	}
-	return removed, nil
+	return buf.Bytes(), nil
 }
 
 // Start the service
 func (d *darwin) Start() (string, error) {
+	return d.StartContext(context.Background())
+}
+
+// StartContext starts the service
+func (d *darwin) StartContext(ctx context.Context) (string, error) {
 	if !d.IsInstalled() {
-		return "", errNotInstalled
+		return "", d.opError("start", ErrNotInstalled)
 	}
 
-	if err := run("launchctl", "load", d.servicePath()); err != nil {
-		return "", err
+	if err := d.cfg.run(ctx, "launchctl", "load", d.servicePath()); err != nil {
+		return "", d.opError("start", err)
 	}
 	return started, nil
 }
 
 // Stop the service
 func (d *darwin) Stop() (string, error) {
+	return d.StopContext(context.Background())
+}
+
+// StopContext stops the service
+func (d *darwin) StopContext(ctx context.Context) (string, error) {
 	if !d.IsInstalled() {
-		return "", errNotInstalled
+		return "", d.opError("stop", ErrNotInstalled)
 	}
 
-	if err := run("launchctl", "unload", d.servicePath()); err != nil {
-		return "", err
+	if err := d.cfg.run(ctx, "launchctl", "unload", d.servicePath()); err != nil {
+		return "", d.opError("stop", err)
 	}
 	return stopped, nil
 }
 
 func (d *darwin) Restart() (string, error) {
-	d.Stop()
+	return d.RestartContext(context.Background())
+}
+
+// RestartContext restarts the service
+func (d *darwin) RestartContext(ctx context.Context) (string, error) {
+	d.StopContext(ctx)
 
 	time.Sleep(50 * time.Millisecond)
-	if s, err := d.Start(); err != nil {
-		return s, err
+	if s, err := d.StartContext(ctx); err != nil {
+		return s, d.opError("restart", err)
 	}
 	return "restarted", nil
 }
 
+func (d *darwin) Reload() (string, error) {
+	return d.ReloadContext(context.Background())
+}
+
+// ReloadContext sends SIGHUP to the service, launchd has no reload
+func (d *darwin) ReloadContext(ctx context.Context) (string, error) {
+	if !d.IsInstalled() {
+		return "", d.opError("reload", ErrNotInstalled)
+	}
+	if err := d.signal(ctx, syscall.SIGHUP); err != nil {
+		return "", d.opError("reload", err)
+	}
+	return reloaded, nil
+}
+
+func (d *darwin) Signal(sig syscall.Signal) error {
+	return d.SignalContext(context.Background(), sig)
+}
+
+func (d *darwin) SignalContext(ctx context.Context, sig syscall.Signal) error {
+	return d.opError("signal", d.signal(ctx, sig))
+}
+
+// signal delivers sig to the process of the job
+func (d *darwin) signal(ctx context.Context, sig syscall.Signal) error {
+	pid, _ := d.checkRunning(ctx)
+	n, err := strconv.Atoi(pid)
+	if err != nil {
+		return err
+	}
+	return d.cfg.signal(ctx, n, sig)
+}
+
 // Status - Get service status
 func (d *darwin) Status() (string, error) {
+	return d.StatusContext(context.Background())
+}
+
+// StatusContext gets service status
+func (d *darwin) StatusContext(ctx context.Context) (string, error) {
+	status, err := statusString(d.InspectContext(ctx))
+	return status, d.opError("status", err)
+}
+
+// Inspect returns structured service status
+func (d *darwin) Inspect() (StatusInfo, error) {
+	return d.InspectContext(context.Background())
+}
+
+// InspectContext returns structured service status
+func (d *darwin) InspectContext(ctx context.Context) (StatusInfo, error) {
+	info := StatusInfo{Backend: launchdBackend, PID: -1}
 	if !d.IsInstalled() {
-		return "", errNotInstalled
+		info.State = StateNotInstalled
+		return info, nil
+	}
+
+	output, err := d.cfg.output(ctx, "launchctl", "list", d.cfg.Name)
+	if ctxErr := contextError(ctx, "launchctl"); ctxErr != nil {
+		return info, d.opError("status", ctxErr)
+	}
+	if err != nil {
+		// not loaded
+		info.State = StateStopped
+		return info, nil
+	}
+	reg := regexp.MustCompile("LastExitStatus\" = (-?[0-9]+);")
+	if data := reg.FindStringSubmatch(string(output)); len(data) > 1 {
+		if status, err := strconv.Atoi(data[1]); err == nil {
+			info.ExitCode = syscall.WaitStatus(status).ExitStatus()
+		}
+	}
+	reg = regexp.MustCompile("PID\" = ([0-9]+);")
+	if data := reg.FindStringSubmatch(string(output)); len(data) > 1 {
+		info.PID, _ = strconv.Atoi(data[1])
+		info.StartedAt = d.startTime(ctx, info.PID)
+		info.State = StateRunning
+		return info, nil
+	}
+	if info.ExitCode != 0 {
+		info.State = StateFailed
+	} else {
+		info.State = StateStopped
+	}
+	return info, nil
+}
+
+// startTime returns start time of pid, zero time if unknown
+func (d *darwin) startTime(ctx context.Context, pid int) time.Time {
+	output, err := d.cfg.output(ctx, "ps", "-o", "lstart=", "-p", strconv.Itoa(pid))
+	if err != nil {
+		return time.Time{}
 	}
+	t, err := time.ParseInLocation(time.ANSIC, strings.TrimSpace(string(output)), time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// load parses the installed property list
+func (d *darwin) load() (ServiceConfig, error) {
+	content, err := ioutil.ReadFile(d.cfg.path(d.servicePath()))
+	if err != nil {
+		return d.cfg, err
+	}
+	plist, err := parsePlist(content)
+	if err != nil {
+		return d.cfg, err
+	}
+	cfg := d.cfg
+	if args, ok := plist["ProgramArguments"].([]interface{}); ok && len(args) > 0 {
+		cfg.Cmd, _ = args[0].(string)
+		cfg.Args = nil
+		for _, arg := range args[1:] {
+			if s, ok := arg.(string); ok {
+				cfg.Args = append(cfg.Args, s)
+			}
+		}
+	}
+	if env, ok := plist["EnvironmentVariables"].(map[string]interface{}); ok && len(env) > 0 {
+		cfg.Environ = make(map[string]string, len(env))
+		for k, v := range env {
+			cfg.Environ[k], _ = v.(string)
+		}
+	}
+	cfg.WorkingDir, _ = plist["WorkingDirectory"].(string)
+	cfg.LogFile, _ = plist["StandardOutPath"].(string)
+	cfg.User, _ = plist["UserName"].(string)
+	cfg.Group, _ = plist["GroupName"].(string)
+	cfg.RestartPolicy = RestartPolicy{}
+	switch keepAlive := plist["KeepAlive"].(type) {
+	case bool:
+		cfg.RestartPolicy.Mode = RestartAlways
+		if !keepAlive {
+			cfg.RestartPolicy.Mode = RestartNever
+		}
+	case map[string]interface{}:
+		cfg.RestartPolicy.Mode = RestartOnFailure
+	}
+	if throttle, ok := plist["ThrottleInterval"].(string); ok {
+		if v, err := strconv.Atoi(throttle); err == nil {
+			cfg.RestartPolicy.Delay = time.Duration(v) * time.Second
+		}
+	}
+	cfg.StopSignal, cfg.StopTimeout, cfg.KillMode = 0, 0, ""
+	if timeout, ok := plist["ExitTimeOut"].(string); ok {
+		if v, err := strconv.Atoi(timeout); err == nil {
+			cfg.StopTimeout = time.Duration(v) * time.Second
+		}
+	}
+	if abandon, _ := plist["AbandonProcessGroup"].(bool); abandon {
+		cfg.KillMode = KillProcess
+	}
+	cfg.Limits = Limits{}
+	if limits, ok := plist["SoftResourceLimits"].(map[string]interface{}); ok {
+		for k, v := range limits {
+			s, _ := v.(string)
+			cfg.Limits.parse(limitLaunchd, k, s)
+		}
+	}
+	if m, ok := parseMarker(content); ok {
+		cfg.Labels = m.Labels
+	}
+	return cfg, nil
+}
+
+// parsePlist decodes top level dict of XML property list into strings,
+// bools, []interface{} and map[string]interface{}
+func parsePlist(data []byte) (map[string]interface{}, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "dict" {
+			return decodePlistDict(dec)
+		}
+	}
+}
+
+func decodePlistDict(dec *xml.Decoder) (map[string]interface{}, error) {
+	dict := make(map[string]interface{})
+	var key string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "key" {
+				if err := dec.DecodeElement(&key, &t); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			value, err := decodePlistValue(dec, t)
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = value
+		case xml.EndElement:
+			return dict, nil
+		}
+	}
+}
 
-	statusAction, _ := d.checkRunning()
-	return statusAction, nil
+func decodePlistValue(dec *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "dict":
+		return decodePlistDict(dec)
+	case "array":
+		var items []interface{}
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				item, err := decodePlistValue(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			case xml.EndElement:
+				return items, nil
+			}
+		}
+	case "true", "false":
+		return start.Name.Local == "true", dec.Skip()
+	}
+	var s string
+	err := dec.DecodeElement(&s, &start)
+	return strings.TrimSpace(s), err
 }
 
 var propertyList = `<?xml version="1.0" encoding="UTF-8"?>
 <!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+{{.Marker}}
 <plist version="1.0">
 <dict>
     <key>Label</key><string>{{html .Name}}</string>
-    <key>EnvironmentVariables</key>
+{{if .User}}    <key>UserName</key><string>{{html .User}}</string>
+{{end}}{{if .Group}}    <key>GroupName</key><string>{{html .Group}}</string>
+{{end}}    <key>EnvironmentVariables</key>
     <dict>
 {{ range $key, $value := .Envs }}<key>{{ $key }}</key>
         <string>{{ $value }}</string>
@@ -215,12 +670,27 @@ var propertyList = `<?xml version="1.0" encoding="UTF-8"?>
     <string>{{.LogFile}}</string>
     <key>StandardOutPath</key>
     <string>{{.LogFile}}</string>
-
+{{if .Limits}}    <key>SoftResourceLimits</key>
+    <dict>
+{{range $key, $value := .Limits}}        <key>{{$key}}</key><integer>{{$value}}</integer>
+{{end}}    </dict>
+{{end}}
     <key>SessionCreate</key>
     <false/>
     <key>KeepAlive</key>
+{{if eq .KeepAlive "always"}}    <true/>
+{{else if eq .KeepAlive "on-failure"}}    <dict>
+        <key>SuccessfulExit</key>
+        <false/>
+    </dict>
+{{else}}    <false/>
+{{end}}{{if .Throttle}}    <key>ThrottleInterval</key>
+    <integer>{{.Throttle}}</integer>
+{{end}}{{if .ExitTimeOut}}    <key>ExitTimeOut</key>
+    <integer>{{.ExitTimeOut}}</integer>
+{{end}}{{if .AbandonGroup}}    <key>AbandonProcessGroup</key>
     <true/>
-    <key>RunAtLoad</key>
+{{end}}    <key>RunAtLoad</key>
     <true/>
     <key>Disabled</key>
     <false/>
@@ -0,0 +1,34 @@
+package supervisor
+
+import "testing"
+
+// testBackend installs services of tests common to the platforms
+const testBackend = launchdBackend
+
+func TestLaunchdDisabled(t *testing.T) {
+	out := `disabled services = {
+	"com.apple.ftp-proxy" => disabled
+	"com.isaax.agent" => enabled
+	"com.isaax.old" => true
+	"com.isaax.new" => false
+}
+login item associations = {
+}
+`
+	tests := []struct {
+		label    string
+		disabled bool
+	}{
+		{"com.apple.ftp-proxy", true},
+		{"com.isaax.agent", false},
+		{"com.isaax.old", true},
+		{"com.isaax.new", false},
+		{"com.isaax.missing", false},
+		{"com.isaax", false},
+	}
+	for _, tt := range tests {
+		if disabled := launchdDisabled([]byte(out), tt.label); disabled != tt.disabled {
+			t.Errorf("launchdDisabled(%q) = %v, want %v", tt.label, disabled, tt.disabled)
+		}
+	}
+}
@@ -5,90 +5,42 @@ import (
 	"strings"
 )
 
-// newService returns new supervised service
-func newService(name, cmd, description, workingDir, logFile string, dependencies []string, environ map[string]string) Service {
-	if _, err := os.Stat("/run/systemd/system"); err == nil {
-		return newSystemDService(name, cmd, description, workingDir, logFile, dependencies, environ)
-	}
-
-	if _, err := os.Stat("/sbin/initctl"); err == nil {
-		return newUpstartService(name, cmd, description, workingDir, logFile, dependencies, environ)
-	}
-
-	if _, err := os.Stat("/sbin/procd"); err == nil {
-		return newProcDService(name, cmd, description, workingDir, logFile, dependencies, environ)
-	}
-
-	return newSystemVService(name, cmd, description, workingDir, logFile, dependencies, environ)
+func init() {
+	RegisterBackend(systemdBackend, newSystemDService, detectPath("/run/systemd/system"))
+	RegisterBackend(upstartBackend, newUpstartService, detectPath("/sbin/initctl"))
+	RegisterBackend(procdBackend, newProcDService, detectPath("/sbin/procd"))
+	RegisterBackend(sysvBackend, newSystemVService, func() (bool, string) {
+		return true, "no other init system detected"
+	})
 }
 
-func getService(name string) Service {
-	if _, err := os.Stat("/run/systemd/system"); err == nil {
-		return &systemD{name: name}
-	}
-
-	if _, err := os.Stat("/sbin/initctl"); err == nil {
-		return &upstart{name: name}
+// detectPath accepts the host when path exists
+func detectPath(path string) BackendDetector {
+	return func() (bool, string) {
+		if _, err := os.Stat(path); err != nil {
+			return false, path + " not found"
+		}
+		return true, path + " exists"
 	}
-
-	if _, err := os.Stat("/sbin/procd"); err == nil {
-		return &procd{name: name}
-	}
-
-	return &systemV{name: name}
 }
 
-func newSystemDService(name, cmd, description, workingDir, logFile string, dependencies []string, environ map[string]string) Service {
-	return &systemD{
-		name:         name,
-		cmd:          cmd,
-		workingDir:   workingDir,
-		logFile:      logFile,
-		restart:      "on-failure",
-		restartSec:   "10",
-		environ:      environ,
-		description:  description,
-		dependencies: dependencies,
-	}
+func newSystemDService(cfg ServiceConfig) Service {
+	return &systemD{cfg: cfg}
 }
 
-func newSystemVService(name, cmd, description, workingDir, logFile string, dependencies []string, environ map[string]string) Service {
-	return &systemV{
-		name:         name,
-		cmd:          cmd,
-		workingDir:   workingDir,
-		logFile:      logFile,
-		environ:      environ,
-		description:  description,
-		dependencies: dependencies,
-	}
+func newSystemVService(cfg ServiceConfig) Service {
+	return &systemV{cfg: cfg}
 }
 
-func newUpstartService(name, cmd, description, workingDir, logFile string, dependencies []string, environ map[string]string) Service {
-	return &upstart{
-		name:         name,
-		cmd:          cmd,
-		workingDir:   workingDir,
-		logFile:      logFile,
-		environ:      environ,
-		description:  description,
-		dependencies: dependencies,
-	}
+func newUpstartService(cfg ServiceConfig) Service {
+	return &upstart{cfg: cfg}
 }
 
-func newProcDService(name, cmd, description, workingDir, logFile string, dependencies []string, environ map[string]string) Service {
-	return &procd{
-		name:         name,
-		cmd:          cmd,
-		workingDir:   workingDir,
-		logFile:      logFile,
-		environ:      environ,
-		description:  description,
-		dependencies: dependencies,
-	}
+func newProcDService(cfg ServiceConfig) Service {
+	return &procd{cfg: cfg}
 }
 
 func legacyUnitFile(name string) Service {
 	name = strings.Replace(name, " ", "_", -1)
-	return &systemD{name: name}
+	return &systemD{cfg: ServiceConfig{Name: name}}
 }
@@ -1,244 +1,596 @@
 package supervisor
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
-	"os/exec"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
+	"time"
 )
 
+const procdBackend = "procd"
+
+// procdPriority is START and STOP of agentProcdConfig, the priority of
+// /etc/rc.d links of other scripts
+const procdPriority = "120"
+
 // procd - standard record (struct) for linux procd version of daemon package
 type procd struct {
-	name         string
-	cmd          string
-	description  string
-	dependencies []string
-	workingDir   string
-	logFile      string
-	environ      map[string]string
+	cfg ServiceConfig
 }
 
 // Standard service path for systemV daemons
 func (u *procd) servicePath() string {
-	return "/etc/init.d/" + u.name
+	return "/etc/init.d/" + u.cfg.Name
+}
+
+func (u *procd) serviceDir() (string, string) {
+	return "/etc/init.d", ""
 }
 
 // Is a service installed
 func (u *procd) IsInstalled() bool {
-	if _, err := os.Stat(u.servicePath()); err == nil {
+	if _, err := os.Stat(u.cfg.path(u.servicePath())); err == nil {
 		return true
 	}
 	return false
 }
 
+// procdInstance reports whether the service is a procd instance, other
+// services get a plain init script managing a pid file
+func (u *procd) procdInstance() bool {
+	return u.cfg.Name == "isaax-agent"
+}
+
+// pidFile is the pid file written by appProcdConfig
+func (u *procd) pidFile() string {
+	return "/var/run/" + u.cfg.Name + ".pid"
+}
+
+// rcLink returns /etc/rc.d start (S) or stop (K) link of the script
+func (u *procd) rcLink(kind string) string {
+	return "/etc/rc.d/" + kind + procdPriority + u.cfg.Name
+}
+
 func (u *procd) ServiceName() string {
-	return u.name
+	return u.cfg.Name
+}
+
+// opError annotates err with the operation and service
+func (u *procd) opError(op string, err error) error {
+	return newOpError(op, u.cfg.Name, procdBackend, err)
 }
 
 // Check service is running
-func (u *procd) checkRunning() (int, error) {
-	output, err := exec.Command(u.servicePath(), "status").Output()
-	if err == nil {
-		if matched, err := regexp.MatchString("running", string(output)); err == nil && matched {
-			reg := regexp.MustCompile("running ([0-9]+)")
-			data := reg.FindStringSubmatch(string(output))
-			if len(data) > 1 {
-				return strconv.Atoi(data[1])
-			}
-			return -1, nil
+func (u *procd) checkRunning(ctx context.Context) (int, error) {
+	info, err := u.state(ctx)
+	if err != nil {
+		return -1, err
+	}
+	if info.State != StateRunning {
+		return -1, ErrNotRunning
+	}
+	return info.PID, nil
+}
+
+// state asks procd over ubus about its instance, plain init scripts exit
+// with 1 from status action when the service is stopped
+func (u *procd) state(ctx context.Context) (StatusInfo, error) {
+	info := StatusInfo{Backend: procdBackend, PID: -1}
+	if u.procdInstance() {
+		filter, err := json.Marshal(map[string]string{"name": u.cfg.Name})
+		if err != nil {
+			return info, err
+		}
+		out, err := u.cfg.output(ctx, "ubus", "call", "service", "list", string(filter))
+		if err != nil {
+			return info, err
+		}
+		info.State, info.PID, info.ExitCode = parseUbusServiceList(out, u.cfg.Name)
+		return info, nil
+	}
+	_, err := u.cfg.output(ctx, u.servicePath(), "status")
+	switch {
+	case err == nil:
+		info.State = StateRunning
+		info.PID = readPidFile(u.pidFile())
+	case exitCode(err) == 1:
+		info.State = StateStopped
+	default:
+		return info, err
+	}
+	return info, nil
+}
+
+// parseUbusServiceList returns state, pid and exit code of service in
+// output of ubus call service list
+func parseUbusServiceList(out []byte, name string) (State, int, int) {
+	var services map[string]struct {
+		Instances map[string]struct {
+			Running  bool `json:"running"`
+			PID      int  `json:"pid"`
+			ExitCode int  `json:"exit_code"`
+		} `json:"instances"`
+	}
+	if err := json.Unmarshal(out, &services); err != nil {
+		return StateUnknown, -1, 0
+	}
+	// instances are named instance1, instance2, ...
+	instances := services[name].Instances
+	names := make([]string, 0, len(instances))
+	for n := range instances {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	state, exit := StateStopped, 0
+	for _, n := range names {
+		i := instances[n]
+		if i.Running {
+			return StateRunning, i.PID, 0
+		}
+		if i.ExitCode != 0 {
+			state, exit = StateFailed, i.ExitCode
 		}
 	}
-	return -1, errNotRunning
+	return state, -1, exit
 }
 
 // Install the service
 func (u *procd) Install(args ...string) (string, error) {
-	if ok, err := checkPrivileges(); !ok {
-		return "", err
-	}
-	srvPath := u.servicePath()
+	return u.InstallContext(context.Background(), args...)
+}
 
-	if u.IsInstalled() {
-		return "", errAlreadyInstalled
+// InstallContext installs the service
+func (u *procd) InstallContext(ctx context.Context, args ...string) (string, error) {
+	if ok, err := u.cfg.privileged(); !ok {
+		return "", u.opError("install", err)
 	}
-
-	file, err := os.Create(srvPath)
+	p, err := u.plan(OpInstall, args)
 	if err != nil {
-		return "", err
+		return "", u.opError("install", err)
+	}
+	if err := u.cfg.apply(ctx, p); err != nil {
+		return "", u.opError("install", err)
 	}
-	defer file.Close()
 
-	var templ *template.Template
-	if u.name == "isaax-agent" {
-		templ, err = template.New("agentProcdConfig").Parse(agentProcdConfig)
-	} else {
-		templ, err = template.New("appProcdConfig").Parse(appProcdConfig)
+	return installed, nil
+}
+
+// Remove the service
+func (u *procd) Remove() (string, error) {
+	return u.RemoveContext(context.Background())
+}
+
+// RemoveContext removes the service, ctx bounds file changes made with
+// commands run through Escalate
+func (u *procd) RemoveContext(ctx context.Context) (string, error) {
+	if ok, err := u.cfg.privileged(); !ok {
+		return "", u.opError("remove", err)
 	}
+	p, err := u.plan(OpRemove, nil)
 	if err != nil {
-		return "", err
+		return "", u.opError("remove", err)
+	}
+	if err := u.cfg.apply(ctx, p); err != nil {
+		return "", u.opError("remove", err)
 	}
+	return removed, nil
+}
 
-	var env string
-	if u.environ != nil {
-		environ := mapToSlice(u.environ)
-		env = environProcd(environ)
+func (u *procd) UpdateEnviron(env map[string]string) (string, error) {
+	if ok, err := u.cfg.privileged(); !ok {
+		return "", u.opError("update", err)
 	}
-	if err := templ.Execute(
-		file,
-		&struct {
-			Name, Description, Args, WorkingDir string
-			Cmd                                 string
-			EnVar                               string
-		}{
-			Name:        u.name,
-			Cmd:         u.cmd,
-			Description: u.description,
-			EnVar:       env,
-			WorkingDir:  u.workingDir,
-			Args:        strings.Join(args, " ")},
-	); err != nil {
-		return "", err
+	updated := *u
+	updated.cfg.Environ = env
+	p, err := updated.plan(OpUpdateEnviron, nil)
+	if err != nil {
+		return "", u.opError("update", err)
 	}
-
-	if err := os.Chmod(srvPath, 0755); err != nil {
-		return "", err
+	if err := u.cfg.apply(context.Background(), p); err != nil {
+		return "", u.opError("update", err)
 	}
-	file.Close()
-	if u.name == "isaax-agent" {
-		if err := exec.Command(u.servicePath(), "enable").Run(); err != nil {
-			return "", err
+	return "updated", nil
+}
+
+// Plan returns side effects of op without applying them
+func (u *procd) Plan(op Operation, args ...string) (*Plan, error) {
+	p, err := u.plan(op, args)
+	return p, u.opError(string(op), err)
+}
+
+func (u *procd) plan(op Operation, args []string) (*Plan, error) {
+	p := u.cfg.newPlan(op, procdBackend)
+	switch op {
+	case OpInstall:
+		if u.IsInstalled() {
+			return nil, ErrAlreadyInstalled
+		}
+		if err := u.cfg.planAccount(p, busyboxTools); err != nil {
+			return nil, err
+		}
+		script, err := u.render(args)
+		if err != nil {
+			return nil, err
+		}
+		p.writeFile(u.servicePath(), 0755, script)
+		// scripts of other services are installed disabled
+		if u.procdInstance() {
+			if u.cfg.offline() {
+				// links created by rc.common enable
+				p.mkdir("/etc/rc.d", 0755)
+				p.symlink("../init.d/"+u.cfg.Name, u.rcLink("S"), false)
+				p.symlink("../init.d/"+u.cfg.Name, u.rcLink("K"), false)
+				break
+			}
+			p.command(false, u.servicePath(), "enable")
+		}
+	case OpRemove:
+		if !u.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		if u.cfg.offline() || !u.procdInstance() {
+			p.remove(u.rcLink("S"), true)
+			p.remove(u.rcLink("K"), true)
 		}
+		p.remove(u.servicePath(), false)
+	case OpEnable:
+		if !u.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		if u.procdInstance() && !u.cfg.offline() {
+			p.command(false, u.servicePath(), "enable")
+			break
+		}
+		// links of rc.common enable, they may exist already
+		p.mkdir("/etc/rc.d", 0755)
+		p.symlink("../init.d/"+u.cfg.Name, u.rcLink("S"), true)
+		p.symlink("../init.d/"+u.cfg.Name, u.rcLink("K"), true)
+	case OpDisable:
+		if !u.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		if u.procdInstance() && !u.cfg.offline() {
+			p.command(false, u.servicePath(), "disable")
+			break
+		}
+		p.remove(u.rcLink("S"), true)
+		p.remove(u.rcLink("K"), true)
+	case OpUpdateEnviron:
+		if !u.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		script, err := u.render(nil)
+		if err != nil {
+			return nil, err
+		}
+		p.writeFile(u.servicePath(), 0755, script)
+	case opReconcile:
+		script, err := u.render(args)
+		if err != nil {
+			return nil, err
+		}
+		p.writeFile(u.servicePath(), 0755, script)
+	default:
+		return nil, ErrNotSupported
 	}
+	return p, nil
+}
 
-	return installed, nil
+// Enable the service at boot
+func (u *procd) Enable() (string, error) {
+	return u.EnableContext(context.Background())
 }
 
-// Remove the service
-func (u *procd) Remove() (string, error) {
-	if ok, err := checkPrivileges(); !ok {
+// EnableContext enables the agent through rc.common, other scripts get
+// its /etc/rc.d links
+func (u *procd) EnableContext(ctx context.Context) (string, error) {
+	if err := u.switchBoot(ctx, OpEnable); err != nil {
 		return "", err
 	}
-	if !u.IsInstalled() {
-		return "", errNotInstalled
-	}
-	if err := os.Remove(u.servicePath()); err != nil {
-		return "", err
-	}
-	return removed, nil
+	return enabled, nil
 }
 
-func (u *procd) UpdateEnviron(env map[string]string) (string, error) {
-	if ok, err := checkPrivileges(); !ok {
+// Disable the service at boot
+func (u *procd) Disable() (string, error) {
+	return u.DisableContext(context.Background())
+}
+
+// DisableContext disables the agent through rc.common, /etc/rc.d links
+// of other scripts are removed
+func (u *procd) DisableContext(ctx context.Context) (string, error) {
+	if err := u.switchBoot(ctx, OpDisable); err != nil {
 		return "", err
 	}
-	srvPath := u.servicePath()
+	return disabled, nil
+}
 
-	if u.IsInstalled() {
-		return "", errAlreadyInstalled
+// switchBoot applies OpEnable or OpDisable
+func (u *procd) switchBoot(ctx context.Context, op Operation) error {
+	if ok, err := u.cfg.privileged(); !ok {
+		return u.opError(string(op), err)
 	}
-
-	file, err := os.Create(srvPath)
+	p, err := u.plan(op, nil)
 	if err != nil {
-		return "", err
+		return u.opError(string(op), err)
 	}
-	defer file.Close()
+	return u.opError(string(op), u.cfg.apply(ctx, p))
+}
+
+func (u *procd) IsEnabled() (bool, error) {
+	return u.IsEnabledContext(context.Background())
+}
+
+// IsEnabledContext checks the /etc/rc.d start link of the script
+func (u *procd) IsEnabledContext(ctx context.Context) (bool, error) {
+	if !u.IsInstalled() {
+		return false, u.opError("status", ErrNotInstalled)
+	}
+	_, err := os.Lstat(u.cfg.path(u.rcLink("S")))
+	return err == nil, nil
+}
 
+// render returns init script content
+func (u *procd) render(args []string) ([]byte, error) {
 	var templ *template.Template
-	if u.name == "isaax-agent" {
+	var err error
+	if u.procdInstance() {
 		templ, err = template.New("agentProcdConfig").Parse(agentProcdConfig)
 	} else {
 		templ, err = template.New("appProcdConfig").Parse(appProcdConfig)
 	}
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if err := u.cfg.systemdOnly(procdBackend); err != nil {
+		return nil, err
+	}
+	if !u.procdInstance() {
+		// app scripts set limits with ulimit
+		if err := u.cfg.Limits.checkUlimit(procdBackend); err != nil {
+			return nil, err
+		}
+	}
+	// app scripts reboot from their respawn loop
+	if err := u.cfg.RestartPolicy.check(procdBackend, !u.procdInstance()); err != nil {
+		return nil, err
+	}
+	stop, stopSet, err := u.cfg.stopping()
+	if err != nil {
+		return nil, err
+	}
+	var stopScript string
+	if u.procdInstance() {
+		// procd sends SIGTERM to the instance, SIGKILL after term_timeout
+		if err := u.cfg.fixedStopSignal(procdBackend); err != nil {
+			return nil, err
+		}
+		if err := u.cfg.fixedKillMode(procdBackend, KillProcess); err != nil {
+			return nil, err
+		}
+	} else if stop.ignoredInBackground() {
+		// busybox sh cannot reset them for the service it starts
+		return nil, fmt.Errorf("%w: stop signal %v on %s scripts", ErrNotSupported, u.cfg.StopSignal, procdBackend)
+	} else if stopSet {
+		stopScript = stop.script("        ", `"$pid_file"`, u.cfg.RestartPolicy.respawns())
+	}
+	if err := u.cfg.Hooks.check(procdBackend, false, true); err != nil {
+		return nil, err
+	}
+	hookStyle, hookIndent := hookScript, "    "
+	if u.procdInstance() {
+		hookStyle, hookIndent = hookRcCommon, "  "
+	}
+	mode, _ := u.cfg.RestartPolicy.mode()
+	// app scripts log to /var/log/$name.log and .err unless LogFile is set
+	stdoutLog, stderrLog := "/var/log/$name.log", "/var/log/$name.err"
+	if u.cfg.LogFile != "" {
+		stdoutLog, stderrLog = u.cfg.LogFile, u.cfg.LogFile
+	}
+	var respawnLoop string
+	if u.cfg.RestartPolicy.respawns() {
+		// the loop is run by another shell, name is not set there
+		expand := strings.NewReplacer("$name", u.cfg.Name).Replace
+		respawnLoop = u.cfg.RestartPolicy.respawnLoop(u.cfg.Cmd+" "+strings.Join(u.cfg.args(args), " "), expand(stdoutLog), expand(stderrLog), stop)
+	}
+	limits := u.cfg.Limits
+	if limits.Core == 0 {
+		// the agent has always dumped core
+		limits.Core = Unlimited
 	}
 
+	var env string
+	if u.cfg.Environ != nil {
+		environ := mapToSlice(u.cfg.Environ)
+		env = environProcd(environ)
+		if u.procdInstance() {
+			env = "procd_set_param env " + environSystemd(environ)
+		}
+	}
+	var buf bytes.Buffer
 	if err := templ.Execute(
-		file,
+		&buf,
 		&struct {
 			Name, Description, Args, WorkingDir string
-			Cmd                                 string
-			EnVar                               string
+			Cmd, LogFile                        string
+			StdoutLog, StderrLog                string
+			Dependencies                        []string
+			EnVar, Marker                       string
+			User, Group, Chuid                  string
+			Limits, Ulimits                     string
+			Respawn, RespawnLoop                string
+			Respawns                            bool
+			Setsid, Stop                        string
+			TermTimeout                         int
+			Hooks                               Hooks
+			HookFuncs                           string
 		}{
-			Name:        u.name,
-			Cmd:         u.cmd,
-			Description: u.description,
-			EnVar:       environProcd(mapToSlice(env)),
-			WorkingDir:  u.workingDir,
-			Args:        strings.Join([]string{}, " ")},
+			Name:         u.cfg.Name,
+			Cmd:          u.cfg.Cmd,
+			LogFile:      u.cfg.LogFile,
+			StdoutLog:    stdoutLog,
+			StderrLog:    stderrLog,
+			Dependencies: u.cfg.Dependencies,
+			Description:  u.cfg.Description,
+			EnVar:        env,
+			User:         u.cfg.User,
+			Group:        u.cfg.Group,
+			Chuid:        u.cfg.chuid(),
+			Limits:       limits.procd(),
+			Ulimits:      u.cfg.Limits.ulimit("        "),
+			Respawn:      u.cfg.RestartPolicy.procd(),
+			RespawnLoop:  respawnLoop,
+			Respawns:     mode != RestartNever,
+			Setsid:       stop.setsid(respawnLoop != ""),
+			Stop:         stopScript,
+			TermTimeout:  seconds(u.cfg.StopTimeout),
+			Hooks:        u.cfg.Hooks,
+			HookFuncs:    u.cfg.Hooks.functions(hookStyle, hookIndent),
+			Marker:       u.cfg.marker(u.servicePath()).comment("# ", ""),
+			WorkingDir:   u.cfg.WorkingDir,
+			Args:         strings.Join(u.cfg.args(args), " ")},
 	); err != nil {
-		return "", err
+		return nil, err
 	}
-
-	if err := os.Chmod(srvPath, 0755); err != nil {
-		return "", err
-	}
-	return "updated", nil
+	return buf.Bytes(), nil
 }
 
 func (u *procd) Restart() (string, error) {
-	if err := exec.Command(u.servicePath(), "restart").Run(); err != nil {
-		return "", err
+	return u.RestartContext(context.Background())
+}
+
+// RestartContext restarts the service
+func (u *procd) RestartContext(ctx context.Context) (string, error) {
+	if err := u.cfg.run(ctx, u.servicePath(), "restart"); err != nil {
+		return "", u.opError("restart", err)
 	}
 	return "restarting", nil
 }
 
+func (u *procd) Reload() (string, error) {
+	return u.ReloadContext(context.Background())
+}
+
+// ReloadContext runs reload action of the init script
+func (u *procd) ReloadContext(ctx context.Context) (string, error) {
+	if ok, err := u.cfg.privileged(); !ok {
+		return "", u.opError("reload", err)
+	}
+	if !u.IsInstalled() {
+		return "", u.opError("reload", ErrNotInstalled)
+	}
+	if err := u.cfg.run(ctx, u.servicePath(), "reload"); err != nil {
+		return "", u.opError("reload", err)
+	}
+	return reloaded, nil
+}
+
+func (u *procd) Signal(sig syscall.Signal) error {
+	return u.SignalContext(context.Background(), sig)
+}
+
+// SignalContext delivers sig to the instance or the process of the pid
+// file
+func (u *procd) SignalContext(ctx context.Context, sig syscall.Signal) error {
+	pid, err := u.checkRunning(ctx)
+	if err == nil {
+		err = u.cfg.signal(ctx, pid, sig)
+	}
+	return u.opError("signal", err)
+}
+
 func (u *procd) PID() (int, error) {
-	return u.checkRunning()
+	return u.checkRunning(context.Background())
 }
 
 // Start the service
 func (u *procd) Start() (string, error) {
-	if ok, err := checkPrivileges(); !ok {
-		return "", err
+	return u.StartContext(context.Background())
+}
+
+// StartContext starts the service
+func (u *procd) StartContext(ctx context.Context) (string, error) {
+	if ok, err := u.cfg.privileged(); !ok {
+		return "", u.opError("start", err)
 	}
 	if !u.IsInstalled() {
-		return "", errNotInstalled
+		return "", u.opError("start", ErrNotInstalled)
 	}
-	if err := exec.Command(u.servicePath(), "start").Run(); err != nil {
-		return "", err
+	if err := u.cfg.run(ctx, u.servicePath(), "start"); err != nil {
+		return "", u.opError("start", err)
 	}
 	return started, nil
 }
 
 // Stop the service
 func (u *procd) Stop() (string, error) {
-	if ok, err := checkPrivileges(); !ok {
-		return "", err
+	return u.StopContext(context.Background())
+}
+
+// StopContext stops the service
+func (u *procd) StopContext(ctx context.Context) (string, error) {
+	if ok, err := u.cfg.privileged(); !ok {
+		return "", u.opError("stop", err)
 	}
 	if !u.IsInstalled() {
-		return "", errNotInstalled
+		return "", u.opError("stop", ErrNotInstalled)
 	}
-	if err := exec.Command(u.servicePath(), "stop").Run(); err != nil {
-		return "", err
+	if err := u.cfg.run(ctx, u.servicePath(), "stop"); err != nil {
+		return "", u.opError("stop", err)
 	}
 	return stopped, nil
 }
 
 // Status - Get service status
 func (u *procd) Status() (string, error) {
-	if ok, err := checkPrivileges(); !ok {
-		return "", err
+	return u.StatusContext(context.Background())
+}
+
+// StatusContext gets service status
+func (u *procd) StatusContext(ctx context.Context) (string, error) {
+	status, err := statusString(u.InspectContext(ctx))
+	return status, u.opError("status", err)
+}
+
+// Inspect returns structured service status
+func (u *procd) Inspect() (StatusInfo, error) {
+	return u.InspectContext(context.Background())
+}
+
+// InspectContext returns structured service status
+func (u *procd) InspectContext(ctx context.Context) (StatusInfo, error) {
+	info := StatusInfo{Backend: procdBackend, PID: -1}
+	if ok, err := u.cfg.privileged(); !ok {
+		return info, u.opError("status", err)
 	}
 	if !u.IsInstalled() {
-		return "Status could not be defined", errNotInstalled
+		info.State = StateNotInstalled
+		return info, nil
 	}
-	pid, err := u.checkRunning()
+	info, err := u.state(ctx)
 	if err != nil {
-		return "", err
+		return info, u.opError("status", err)
+	}
+	if info.PID > 0 {
+		info.StartedAt = processStartTime(info.PID)
 	}
-	return "(pid: " + strconv.Itoa(pid) + ")", nil
+	return info, nil
 }
 
-// MapToSlice converts map to slice in format k=v
+// MapToSlice converts map to slice in format k=v, sorted by key
 func mapToSlice(m map[string]string) []string {
 	v := make([]string, 0, len(m))
 	for key, value := range m {
 		v = append(v, fmt.Sprintf("%s=%s", key, value))
 	}
+	sort.Strings(v)
 	return v
 }
 
@@ -265,20 +617,120 @@ func environProcd(environ []string) string {
 
 }
 
-func checkPrivileges() (bool, error) {
+// parseProcdRespawn reverses RestartPolicy.procd
+func parseProcdRespawn(params string) RestartPolicy {
+	var p RestartPolicy
+	f := strings.Fields(params)
+	if len(f) != 3 {
+		return p
+	}
+	threshold, _ := strconv.Atoi(f[0])
+	timeout, _ := strconv.Atoi(f[1])
+	p.Window = time.Duration(threshold) * time.Second
+	p.Delay = time.Duration(timeout) * time.Second
+	p.MaxAttempts, _ = strconv.Atoi(f[2])
+	if p.MaxAttempts == 0 {
+		p.Mode = RestartAlways
+	}
+	return p
+}
 
-	if output, err := exec.Command("id", "-g").Output(); err == nil {
-		if gid, parseErr := strconv.ParseUint(strings.TrimSpace(string(output)), 10, 32); parseErr == nil {
-			if gid == 0 {
-				return true, nil
+// load parses the installed init script
+func (u *procd) load() (ServiceConfig, error) {
+	content, err := ioutil.ReadFile(u.cfg.path(u.servicePath()))
+	if err != nil {
+		return u.cfg, err
+	}
+	cfg := u.cfg
+	cfg.LogFile, cfg.Dependencies, cfg.Environ = "", nil, nil
+	cfg.Limits = Limits{}
+	cfg.RestartPolicy = RestartPolicy{}
+	cfg.StopSignal, cfg.StopTimeout, cfg.KillMode = 0, 0, ""
+	cfg.Hooks = Hooks{}
+	if u.procdInstance() {
+		// procd signals the main process of instances only
+		cfg.KillMode = KillProcess
+		// instances respawn unless the policy is RestartNever
+		cfg.RestartPolicy.Mode = RestartNever
+		cfg.Hooks.parseFunctions(hookRcCommon, string(content))
+	} else {
+		cfg.RestartPolicy.parseRespawnLoop(string(content))
+		cfg.Hooks.parseFunctions(hookScript, string(content))
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "# "+cfg.Name+" "):
+			// script header
+			cfg.Description = strings.TrimPrefix(line, "# "+cfg.Name+" ")
+		case strings.HasPrefix(line, "if [ -x /etc/init.d/"):
+			if dep, ok := between(line, "if [ -x /etc/init.d/", " ]; then"); ok {
+				cfg.Dependencies = append(cfg.Dependencies, dep)
+			}
+		case strings.HasPrefix(line, `procd_set_param command /bin/sh -c "`):
+			// the shell changes to WorkingDir and appends output to LogFile
+			if cmdline, ok := between(line, `procd_set_param command /bin/sh -c "`, `"`); ok {
+				if strings.HasPrefix(cmdline, "cd ") {
+					if i := strings.Index(cmdline, " && "); i > 0 {
+						cfg.WorkingDir, cmdline = cmdline[len("cd "):i], cmdline[i+len(" && "):]
+					}
+				}
+				cmdline = strings.TrimPrefix(cmdline, "exec ")
+				if strings.HasSuffix(cmdline, " 2>&1") {
+					cmdline, cfg.LogFile = splitLogRedirect(strings.TrimSuffix(cmdline, " 2>&1"), " >> ")
+				}
+				cfg.Cmd, cfg.Args = splitCommandLine(cmdline)
+			}
+		case strings.HasPrefix(line, "procd_set_param env "):
+			cfg.Environ = parseQuotedEnv(line)
+		case strings.HasPrefix(line, `stdout_log="`):
+			if log := strings.Trim(strings.TrimPrefix(line, "stdout_log="), `"`); log != "/var/log/$name.log" {
+				cfg.LogFile = log
+			}
+		case strings.HasPrefix(line, "procd_set_param command "):
+			cfg.Cmd, cfg.Args = splitCommandLine(strings.TrimPrefix(line, "procd_set_param command "))
+		case strings.HasPrefix(line, `cmd="`):
+			cfg.Cmd, cfg.Args = splitCommandLine(strings.Trim(strings.TrimPrefix(line, "cmd="), `"`))
+		case strings.HasPrefix(line, `dir="`):
+			cfg.WorkingDir = strings.Trim(strings.TrimPrefix(line, "dir="), `"`)
+		case strings.HasPrefix(line, "export "):
+			cfg.Environ = parseQuotedEnv(line)
+		case strings.HasPrefix(line, `user="`):
+			if user := strings.Trim(strings.TrimPrefix(line, "user="), `"`); user != "root" {
+				cfg.User = user
+			}
+		case strings.HasPrefix(line, "procd_set_param user "):
+			cfg.User = strings.TrimPrefix(line, "procd_set_param user ")
+		case strings.HasPrefix(line, "procd_set_param group "):
+			cfg.Group = strings.TrimPrefix(line, "procd_set_param group ")
+		case strings.HasPrefix(line, "start-stop-daemon "):
+			cfg.User, cfg.Group = parseChuid(line)
+		case line == "procd_set_param respawn":
+			cfg.RestartPolicy = RestartPolicy{}
+		case strings.HasPrefix(line, "procd_set_param respawn "):
+			cfg.RestartPolicy = parseProcdRespawn(strings.TrimPrefix(line, "procd_set_param respawn "))
+		case strings.HasPrefix(line, "procd_set_param term_timeout "):
+			if v, err := strconv.Atoi(strings.TrimPrefix(line, "procd_set_param term_timeout ")); err == nil {
+				cfg.StopTimeout = time.Duration(v) * time.Second
+			}
+		case strings.HasPrefix(line, "kill -"), strings.HasPrefix(line, "while kill -0 $pid "):
+			parseStopScript(&cfg, line)
+		case strings.HasPrefix(line, "procd_set_param limits "):
+			cfg.Limits.parseProcd(strings.TrimPrefix(line, "procd_set_param limits "))
+		case strings.HasPrefix(line, "ulimit "):
+			if f := strings.Fields(line); len(f) == 3 {
+				cfg.Limits.parse(limitUlimit, f[1], f[2])
 			}
-			return false, errPermissionDenied
 		}
 	}
-	return false, errOSNotSupported
+	if m, ok := parseMarker(content); ok {
+		cfg.Labels = m.Labels
+	}
+	return cfg, nil
 }
 
 var agentProcdConfig = `#!/bin/sh /etc/rc.common
+{{.Marker}}
 
 # {{.Name}} {{.Description}}
 USE_PROCD=1
@@ -290,28 +742,47 @@ start_service() {
   if [ -r /etc/init.d/isaax-project ]; then
     /etc/init.d/isaax-project start
   fi
-  procd_open_instance
-  procd_set_param command {{.Cmd}} {{.Args}}
-
-  # respawn automatically if something died, be careful if you have an alternative process supervisor
-  # if process dies sooner than respawn_threshold, it is considered crashed and after 5 retries the service is stopped
-  procd_set_param respawn
-
-  procd_set_param limits core="unlimited"  # If you need to set ulimit for your process
+{{range .Dependencies}}  if [ -x /etc/init.d/{{.}} ]; then
+    /etc/init.d/{{.}} start
+  fi
+{{end}}{{if .Hooks.PreStart}}  pre_start || return 1
+{{end}}  procd_open_instance
+{{if or .LogFile .WorkingDir}}  procd_set_param command /bin/sh -c "{{if .WorkingDir}}cd {{.WorkingDir}} && {{end}}exec {{.Cmd}} {{.Args}}{{if .LogFile}} >> {{.LogFile}} 2>&1{{end}}"
+{{else}}  procd_set_param command {{.Cmd}} {{.Args}}
+{{end}}{{if .EnVar}}  {{.EnVar}}
+{{end}}{{if .User}}  procd_set_param user {{.User}}
+{{end}}{{if .Group}}  procd_set_param group {{.Group}}
+{{end}}
+{{if .Respawns}}  # respawn automatically if something died, be careful if you have an alternative process supervisor
+  # if process dies sooner than respawn_threshold, it is considered crashed and after respawn_retry crashes the service is stopped, 0 retries forever
+  # respawn takes respawn_threshold, respawn_timeout and respawn_retry, procd defaults to 3600 5 5
+  procd_set_param respawn{{if .Respawn}} {{.Respawn}}{{end}}
+{{end}}{{if .TermTimeout}}  procd_set_param term_timeout {{.TermTimeout}}
+{{end}}{{if not .Hooks.Reload}}  # reload sends SIGHUP to the instance
+  procd_set_param reload_signal HUP
+{{end}}
+  procd_set_param limits {{.Limits}}  # If you need to set ulimit for your process
   procd_close_instance
 }
-`
+{{if .HookFuncs}}
+{{.HookFuncs}}
+{{end}}`
 var appProcdConfig = `#!/bin/sh
+{{.Marker}}
 
+# {{.Name}} {{.Description}}
 dir="{{.WorkingDir}}"
 cmd="{{.Cmd}} {{.Args}}"
-user="root"
+user="{{if .User}}{{.User}}{{else}}root{{end}}"
 {{.EnVar}}
 name="{{.Name}}"
 pid_file="/var/run/$name.pid"
-stdout_log="/var/log/$name.log"
-stderr_log="/var/log/$name.err"
-
+stdout_log="{{.StdoutLog}}"
+stderr_log="{{.StderrLog}}"
+{{if .RespawnLoop}}
+# respawn runs the service again when it exits
+respawn='{{.RespawnLoop}}'
+{{end}}
 get_pid() {
     cat "$pid_file"
 }
@@ -319,26 +790,45 @@ get_pid() {
 is_running() {
     [ -f "$pid_file" ] && kill -0 $(get_pid) > /dev/null 2>&1
 }
-
+{{if .HookFuncs}}
+{{.HookFuncs}}
+{{end}}
 case "$1" in
-    start)
+    start|boot)
     if is_running; then
         echo "Already started"
     else
         echo "Starting $name"
         cd "$dir"
-        $cmd >> "$stdout_log" 2>> "$stderr_log" &
+{{range .Dependencies}}        if [ -x /etc/init.d/{{.}} ]; then
+            /etc/init.d/{{.}} start
+        fi
+{{end}}{{if .Hooks.PreStart}}        pre_start || exit 1
+{{end}}{{if .Ulimits}}{{.Ulimits}}
+{{end}}{{if .User}}        if command -v start-stop-daemon > /dev/null 2>&1; then
+            start-stop-daemon -S -b -m -p "$pid_file" -c {{.Chuid}} -x /bin/sh -- -c {{if .RespawnLoop}}"$respawn"{{else}}"exec $cmd >> \"$stdout_log\" 2>> \"$stderr_log\""{{end}}
+        else
+{{if .RespawnLoop}}            {{.Setsid}}su -s /bin/sh -c "$respawn" "$user" < /dev/null > /dev/null 2>&1 &
+            echo $! > "$pid_file"
+{{else}}            su -s /bin/sh -c "{{if .Setsid}}{{.Setsid}}{{else}}exec {{end}}$cmd >> \"$stdout_log\" 2>> \"$stderr_log\" & echo \$!" "$user" > "$pid_file"
+{{end}}        fi
+{{else if .RespawnLoop}}        {{.Setsid}}/bin/sh -c "$respawn" < /dev/null > /dev/null 2>&1 &
         echo $! > "$pid_file"
-        if ! is_running; then
+{{else}}        {{.Setsid}}$cmd >> "$stdout_log" 2>> "$stderr_log" &
+        echo $! > "$pid_file"
+{{end}}        if ! is_running; then
             echo "Unable to start, see $stdout_log and $stderr_log"
             exit 1
         fi
-    fi
+{{if .Hooks.PostStart}}        post_start
+{{end}}    fi
     ;;
-    stop)
+    stop|shutdown)
     if is_running; then
         echo -n "Stopping $name.."
-        kill $(get_pid)
+{{if .Hooks.PreStop}}        pre_stop
+{{end}}{{if .Stop}}{{.Stop}}
+{{else}}        kill $(get_pid)
         for i in 1 2 3 4 5 6 7 8 9 10
         # for i in $(seq 10)
         do
@@ -349,7 +839,7 @@ case "$1" in
             echo -n "."
             sleep 1
         done
-        echo
+{{end}}        echo
 
         if is_running; then
             echo "Not stopped; may still be shutting down or shutdown may have failed"
@@ -359,7 +849,8 @@ case "$1" in
             if [ -f "$pid_file" ]; then
                 rm "$pid_file"
             fi
-        fi
+{{if .Hooks.PostStop}}            post_stop
+{{end}}        fi
     else
         echo "Not running"
     fi
@@ -380,8 +871,17 @@ case "$1" in
         exit 1
     fi
     ;;
+    reload)
+    if is_running; then
+{{if .Hooks.Reload}}        reload
+{{else}}        kill -HUP $(get_pid)
+{{end}}    else
+        echo "Not running"
+        exit 1
+    fi
+    ;;
     *)
-    echo "Usage: $0 {start|stop|restart|status}"
+    echo "Usage: $0 {start|stop|restart|status|reload}"
     exit 1
     ;;
 esac
@@ -0,0 +1,136 @@
+package supervisor
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseUbusServiceList(t *testing.T) {
+	tests := []struct {
+		name  string
+		out   string
+		state State
+		pid   int
+		exit  int
+	}{
+		{"running", `{
+	"isaax-agent": {
+		"instances": {
+			"instance1": {
+				"running": true,
+				"pid": 1530,
+				"command": [
+					"/usr/bin/isaax-agent"
+				],
+				"term_timeout": 5,
+				"respawn": {
+					"threshold": 3600,
+					"timeout": 5,
+					"retry": 5
+				}
+			}
+		}
+	}
+}`, StateRunning, 1530, 0},
+		{"exited", `{
+	"isaax-agent": {
+		"instances": {
+			"instance1": {
+				"running": false,
+				"command": [
+					"/usr/bin/isaax-agent"
+				],
+				"term_timeout": 5,
+				"exit_code": 2
+			}
+		}
+	}
+}`, StateFailed, -1, 2},
+		{"stopped", `{"isaax-agent": {"instances": {"instance1": {"running": false}}}}`, StateStopped, -1, 0},
+		{"second instance running", `{"isaax-agent": {"instances": {"instance1": {"running": false, "exit_code": 1}, "instance2": {"running": true, "pid": 9}}}}`, StateRunning, 9, 0},
+		{"other service", `{"dnsmasq": {"instances": {"instance1": {"running": true, "pid": 700}}}}`, StateStopped, -1, 0},
+		{"empty", `{}`, StateStopped, -1, 0},
+		{"invalid", `Command failed: Not found`, StateUnknown, -1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, pid, exit := parseUbusServiceList([]byte(tt.out), "isaax-agent")
+			if state != tt.state || pid != tt.pid || exit != tt.exit {
+				t.Errorf("parseUbusServiceList() = %v, %d, %d, want %v, %d, %d", state, pid, exit, tt.state, tt.pid, tt.exit)
+			}
+		})
+	}
+}
+
+func TestProcdRoundTrip(t *testing.T) {
+	base := ServiceConfig{
+		Backend:      procdBackend,
+		Cmd:          "/usr/bin/app",
+		Args:         []string{"-v", "--port", "8080"},
+		Description:  "Test application",
+		WorkingDir:   "/opt/app",
+		LogFile:      "/var/log/app.log",
+		Dependencies: []string{"dnsmasq", "mosquitto"},
+		Environ:      map[string]string{"MODE": "production", "GREETING": "hello world"},
+		Labels:       map[string]string{"team": "edge"},
+		StopTimeout:  20 * time.Second,
+	}
+	tests := []struct {
+		name string
+		cfg  func(cfg *ServiceConfig)
+	}{
+		{"agent", func(cfg *ServiceConfig) {
+			cfg.Name = "isaax-agent"
+			cfg.KillMode = KillProcess
+			// the agent dumps core unless limited
+			cfg.Limits = Limits{Core: Unlimited, NOFILE: 4096}
+			// procd respawns after any exit, the mode is not kept
+			cfg.RestartPolicy = RestartPolicy{Delay: 5 * time.Second, MaxAttempts: 3, Window: time.Minute}
+			cfg.Hooks = Hooks{PreStart: []string{"mkdir -p /run/app"}, Reload: []string{"kill -USR1 $(pidof app)"}}
+		}},
+		{"app", func(cfg *ServiceConfig) {
+			cfg.Name = "app"
+			cfg.KillMode = KillGroup
+			cfg.StopSignal = syscall.SIGTERM
+			cfg.Limits = Limits{NOFILE: 4096}
+			cfg.RestartPolicy = RestartPolicy{Mode: RestartAlways, Delay: 5 * time.Second, MaxAttempts: 3, Window: time.Minute}
+			cfg.Hooks = Hooks{PostStart: []string{"logger started"}, PostStop: []string{"rm -rf /run/app"}}
+		}},
+		{"app default log", func(cfg *ServiceConfig) {
+			cfg.Name = "app"
+			cfg.LogFile = ""
+			cfg.StopTimeout = 0
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			tt.cfg(&cfg)
+			checkRoundTrip(t, cfg)
+		})
+	}
+}
+
+// renderProcd renders the init script of cfg
+func renderProcd(t *testing.T, cfg ServiceConfig) string {
+	script, err := (&procd{cfg: cfg}).render(nil)
+	if err != nil {
+		t.Fatalf("render() failed: %v", err)
+	}
+	return string(script)
+}
+
+func TestProcdReloadSignal(t *testing.T) {
+	const param = "procd_set_param reload_signal HUP\n"
+	cfg := ServiceConfig{Name: "isaax-agent", Cmd: "/usr/bin/isaax-agent"}
+	if script := renderProcd(t, cfg); !strings.Contains(script, param) {
+		t.Errorf("script lacks %q:\n%s", param, script)
+	}
+	// the reload hook replaces the signal
+	cfg.Hooks.Reload = []string{"kill -USR1 $(pidof isaax-agent)"}
+	if script := renderProcd(t, cfg); strings.Contains(script, param) {
+		t.Errorf("script with reload hook has %q:\n%s", param, script)
+	}
+}
@@ -0,0 +1,293 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	systemdBusName      = "org.freedesktop.systemd1"
+	systemdBusPath      = "/org/freedesktop/systemd1"
+	systemdManagerIface = "org.freedesktop.systemd1.Manager"
+)
+
+// unitManager controls systemd units
+type unitManager interface {
+	// systemctl performs systemctl verb, e.g. start or daemon-reload, on units
+	systemctl(ctx context.Context, verb string, units ...string) error
+	// properties returns unit properties formatted as by systemctl show
+	properties(ctx context.Context, unit string, names ...string) (map[string]string, error)
+	Close() error
+}
+
+// manager returns systemd D-Bus API when commands run on this host and
+// the system or session bus of the scope is reachable, systemctl otherwise
+func (s *systemD) manager(ctx context.Context) unitManager {
+	fallback := &systemctlManager{cfg: &s.cfg}
+	switch s.cfg.Executor.(type) {
+	case nil, LocalExecutor:
+		if s.cfg.offline() || s.cfg.escalating() {
+			// systemctl is run through the escalation tool
+			break
+		}
+		dial := dialSystemBus
+		if s.cfg.Scope == ScopeUser {
+			dial = dialSessionBus
+		}
+		if bus, err := dial(ctx); err == nil {
+			return &busManager{bus: bus, fallback: fallback}
+		}
+	}
+	return fallback
+}
+
+// systemctl performs verb on units through the unit manager
+func (s *systemD) systemctl(ctx context.Context, verb string, units ...string) error {
+	m := s.manager(ctx)
+	defer m.Close()
+	return m.systemctl(ctx, verb, units...)
+}
+
+// apply applies p, systemctl commands are performed by the unit manager
+func (s *systemD) apply(ctx context.Context, p *Plan) error {
+	m := s.manager(ctx)
+	defer m.Close()
+	return s.cfg.applyWith(ctx, p, func(ctx context.Context, cmd Command) error {
+		args := cmd.Args
+		if len(args) > 0 && args[0] == "--user" {
+			// the manager is of the scope already
+			args = args[1:]
+		}
+		if cmd.Path == "systemctl" && len(args) > 0 {
+			return m.systemctl(ctx, args[0], args[1:]...)
+		}
+		_, err := s.cfg.execute(ctx, cmd)
+		return err
+	})
+}
+
+// systemctlManager runs systemctl through the configured Executor
+type systemctlManager struct {
+	cfg *ServiceConfig
+}
+
+// scope returns systemctl options selecting the manager of the scope
+func (m *systemctlManager) scope() []string {
+	if m.cfg.Scope == ScopeUser {
+		return []string{"--user"}
+	}
+	return nil
+}
+
+func (m *systemctlManager) systemctl(ctx context.Context, verb string, units ...string) error {
+	args := append(m.scope(), verb)
+	return m.cfg.run(ctx, "systemctl", append(args, units...)...)
+}
+
+func (m *systemctlManager) properties(ctx context.Context, unit string, names ...string) (map[string]string, error) {
+	args := append(m.scope(), "show")
+	for _, n := range names {
+		args = append(args, "-p", n)
+	}
+	out, err := m.cfg.output(ctx, "systemctl", append(args, unit)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseSystemctlShow(out), nil
+}
+
+func (m *systemctlManager) Close() error {
+	return nil
+}
+
+// busManager calls org.freedesktop.systemd1 on the system bus, calls the
+// bus fails to perform are retried with systemctl
+type busManager struct {
+	bus      *dbusConn
+	fallback *systemctlManager
+	// broken is set once the connection failed, later calls go to
+	// systemctl directly
+	broken bool
+}
+
+// busJobs maps systemctl verbs to Manager methods queueing a job
+var busJobs = map[string]string{
+	"start":   "StartUnit",
+	"stop":    "StopUnit",
+	"restart": "RestartUnit",
+	"reload":  "ReloadUnit",
+}
+
+func (m *busManager) systemctl(ctx context.Context, verb string, units ...string) error {
+	if !m.broken {
+		err := m.busSystemctl(ctx, verb, units...)
+		if !m.failed(ctx, err) {
+			return err
+		}
+	}
+	return m.fallback.systemctl(ctx, verb, units...)
+}
+
+// busSystemctl performs verb on units with Manager methods
+func (m *busManager) busSystemctl(ctx context.Context, verb string, units ...string) error {
+	switch verb {
+	case "daemon-reload":
+		return m.call(ctx, "Reload", "")
+	case "enable":
+		// like systemctl enable without --force, reload so the manager
+		// sees changed unit files
+		if err := m.call(ctx, "EnableUnitFiles", "asbb", units, false, false); err != nil {
+			return err
+		}
+		return m.call(ctx, "Reload", "")
+	case "disable":
+		if err := m.call(ctx, "DisableUnitFiles", "asb", units, false); err != nil {
+			return err
+		}
+		return m.call(ctx, "Reload", "")
+	}
+	method, ok := busJobs[verb]
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := m.subscribe(ctx); err != nil {
+		return err
+	}
+	for _, unit := range units {
+		if err := m.job(ctx, verb, method, unit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscribe asks for JobRemoved signals of the manager
+func (m *busManager) subscribe(ctx context.Context) error {
+	rule := "type='signal',sender='" + systemdBusName + "',interface='" + systemdManagerIface + "',member='JobRemoved'"
+	if _, err := m.bus.call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "AddMatch", "s", rule); err != nil {
+		return err
+	}
+	return m.call(ctx, "Subscribe", "")
+}
+
+// job queues job of unit and waits for it to finish, as systemctl does
+func (m *busManager) job(ctx context.Context, verb, method, unit string) error {
+	out, err := m.bus.call(ctx, systemdBusName, systemdBusPath, systemdManagerIface, method, "ss", unit, "replace")
+	if err != nil {
+		return err
+	}
+	if len(out) == 0 {
+		return fmt.Errorf("%s of %s returned no job", method, unit)
+	}
+	job, _ := out[0].(string)
+	// JobRemoved(u id, o job, s unit, s result)
+	body, err := m.bus.waitSignal(ctx, systemdManagerIface, "JobRemoved", func(body []interface{}) bool {
+		return len(body) == 4 && body[1] == job
+	})
+	if err != nil {
+		return err
+	}
+	if result, _ := body[3].(string); result != "done" {
+		return &jobError{verb: verb, unit: unit, result: result}
+	}
+	return nil
+}
+
+// jobError is a job of systemd which did not finish with result done
+type jobError struct {
+	verb, unit, result string
+}
+
+func (e *jobError) Error() string {
+	return fmt.Sprintf("%s job of %s finished with result %q", e.verb, e.unit, e.result)
+}
+
+func (m *busManager) properties(ctx context.Context, unit string, names ...string) (map[string]string, error) {
+	if !m.broken {
+		props, err := m.busProperties(ctx, unit, names...)
+		if !m.failed(ctx, err) {
+			return props, err
+		}
+	}
+	return m.fallback.properties(ctx, unit, names...)
+}
+
+// busProperties gets unit properties with org.freedesktop.DBus.Properties
+func (m *busManager) busProperties(ctx context.Context, unit string, names ...string) (map[string]string, error) {
+	out, err := m.bus.call(ctx, systemdBusName, systemdBusPath, systemdManagerIface, "LoadUnit", "s", unit)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("LoadUnit of %s returned no object", unit)
+	}
+	path, _ := out[0].(string)
+	props := make(map[string]string, len(names))
+	for _, iface := range []string{"org.freedesktop.systemd1.Unit", "org.freedesktop.systemd1.Service"} {
+		out, err := m.bus.call(ctx, systemdBusName, path, "org.freedesktop.DBus.Properties", "GetAll", "s", iface)
+		if err != nil {
+			return nil, err
+		}
+		if len(out) == 0 {
+			continue
+		}
+		all, _ := out[0].(map[string]interface{})
+		for _, n := range names {
+			if v, ok := all[n]; ok {
+				props[n] = formatProperty(v)
+			}
+		}
+	}
+	return props, nil
+}
+
+// failed reports whether err of a call is a failure of the bus rather than
+// an answer of systemd, such calls are retried with systemctl. Errors
+// other than replies mark the connection broken.
+func (m *busManager) failed(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var reply *dbusError
+	if errors.As(err, &reply) {
+		return !strings.HasPrefix(reply.Name, systemdBusName+".")
+	}
+	var job *jobError
+	if errors.As(err, &job) {
+		return false
+	}
+	if !errors.Is(err, ErrNotSupported) {
+		m.broken = true
+	}
+	return true
+}
+
+// call calls method of the manager object discarding its reply
+func (m *busManager) call(ctx context.Context, method, sig string, args ...interface{}) error {
+	_, err := m.bus.call(ctx, systemdBusName, systemdBusPath, systemdManagerIface, method, sig, args...)
+	return err
+}
+
+func (m *busManager) Close() error {
+	return m.bus.Close()
+}
+
+// formatProperty formats D-Bus property value as systemctl show does
+func formatProperty(v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return "yes"
+		}
+		return "no"
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatProperty(item)
+		}
+		return strings.Join(items, " ")
+	}
+	return fmt.Sprint(v)
+}
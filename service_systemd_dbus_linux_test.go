@@ -0,0 +1,235 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSystemd owns org.freedesktop.systemd1 on a test bus and answers
+// the Manager methods used by busManager
+type fakeSystemd struct {
+	bus *dbusConn
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func startFakeSystemd(t *testing.T, address string) *fakeSystemd {
+	f := &fakeSystemd{bus: dialTestBus(t, address)}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := f.bus.call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "RequestName", "su", systemdBusName, uint32(0)); err != nil {
+		t.Fatalf("RequestName() failed: %v", err)
+	}
+	go f.serve()
+	return f
+}
+
+// serve answers method calls until the connection is closed
+func (f *fakeSystemd) serve() {
+	for job := uint32(1); ; {
+		msg, err := f.bus.receive()
+		if err != nil {
+			return
+		}
+		if msg.typ != dbusMethodCall {
+			continue
+		}
+		member, _ := msg.fields[dbusFieldMember].(string)
+		f.mu.Lock()
+		f.calls = append(f.calls, strings.TrimSpace(member+" "+formatProperty(msg.body)))
+		f.mu.Unlock()
+
+		switch member {
+		case "Subscribe", "Reload", "EnableUnitFiles", "DisableUnitFiles":
+			f.reply(msg, "")
+		case "StartUnit", "StopUnit", "RestartUnit":
+			unit, _ := msg.body[0].(string)
+			path := systemdBusPath + "/job/" + strconv.Itoa(int(job))
+			f.reply(msg, "o", path)
+			result := "done"
+			if strings.HasPrefix(unit, "failing") {
+				result = "failed"
+			}
+			f.bus.write(dbusSignal, []interface{}{
+				[]interface{}{byte(dbusFieldPath), dbusVariant{"o", systemdBusPath}},
+				[]interface{}{byte(dbusFieldInterface), dbusVariant{"s", systemdManagerIface}},
+				[]interface{}{byte(dbusFieldMember), dbusVariant{"s", "JobRemoved"}},
+			}, "uoss", []interface{}{job, path, unit, result})
+			job++
+		case "LoadUnit":
+			if unit, _ := msg.body[0].(string); strings.HasPrefix(unit, "missing") {
+				f.fail(msg, "org.freedesktop.systemd1.NoSuchUnit", "Unit "+unit+" not found.")
+				continue
+			}
+			f.reply(msg, "o", systemdBusPath+"/unit/app_2eservice")
+		case "GetAll":
+			props := []interface{}{
+				[]interface{}{"ActiveState", dbusVariant{"s", "active"}},
+				[]interface{}{"CanReload", dbusVariant{"b", false}},
+			}
+			if iface, _ := msg.body[0].(string); iface == "org.freedesktop.systemd1.Service" {
+				props = []interface{}{
+					[]interface{}{"MainPID", dbusVariant{"u", uint32(1234)}},
+					[]interface{}{"ExecMainStatus", dbusVariant{"i", int32(0)}},
+					[]interface{}{"Environment", dbusVariant{"as", []string{"A=1", "B=2"}}},
+				}
+			}
+			f.reply(msg, "a{sv}", props)
+		default:
+			f.fail(msg, "org.freedesktop.DBus.Error.UnknownMethod", "Unknown method "+member)
+		}
+	}
+}
+
+func (f *fakeSystemd) reply(call *dbusMessage, sig string, args ...interface{}) {
+	f.write(dbusMethodReturn, call, nil, sig, args)
+}
+
+func (f *fakeSystemd) fail(call *dbusMessage, name, message string) {
+	f.write(dbusErrorReply, call, []interface{}{byte(dbusFieldErrorName), dbusVariant{"s", name}}, "s", []interface{}{message})
+}
+
+func (f *fakeSystemd) write(typ byte, call *dbusMessage, field []interface{}, sig string, args []interface{}) {
+	sender, _ := call.fields[dbusFieldSender].(string)
+	fields := []interface{}{
+		[]interface{}{byte(dbusFieldReplySerial), dbusVariant{"u", call.serial}},
+		[]interface{}{byte(dbusFieldDestination), dbusVariant{"s", sender}},
+	}
+	if field != nil {
+		fields = append(fields, field)
+	}
+	f.bus.write(typ, fields, sig, args)
+}
+
+// takeCalls returns methods called since the last call with their
+// arguments
+func (f *fakeSystemd) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+// recordExecutor records commands and prints stdout of systemctl show
+type recordExecutor struct {
+	commands []string
+}
+
+func (e *recordExecutor) Execute(ctx context.Context, cmd Command) ([]byte, []byte, error) {
+	e.commands = append(e.commands, cmd.String())
+	if len(cmd.Args) > 0 && cmd.Args[0] == "show" {
+		return []byte("ActiveState=inactive\n"), nil, nil
+	}
+	return nil, nil, nil
+}
+
+func newTestBusManager(t *testing.T) (*busManager, *fakeSystemd, *recordExecutor) {
+	address := startBus(t)
+	f := startFakeSystemd(t, address)
+	rec := &recordExecutor{}
+	m := &busManager{bus: dialTestBus(t, address), fallback: &systemctlManager{cfg: &ServiceConfig{Executor: rec}}}
+	return m, f, rec
+}
+
+func TestBusManagerSystemctl(t *testing.T) {
+	m, f, rec := newTestBusManager(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		verb     string
+		unit     string
+		calls    []string
+		commands []string
+		err      bool
+	}{
+		{"start", "app.service", []string{"Subscribe", "StartUnit app.service replace"}, nil, false},
+		{"restart", "failing.service", []string{"Subscribe", "RestartUnit failing.service replace"}, nil, true},
+		{"enable", "app.service", []string{"EnableUnitFiles app.service no no", "Reload"}, nil, false},
+		{"disable", "app.service", []string{"DisableUnitFiles app.service no", "Reload"}, nil, false},
+		{"daemon-reload", "", []string{"Reload"}, nil, false},
+		// the fake has no ReloadUnit, the call is retried with systemctl
+		{"reload", "app.service", []string{"Subscribe", "ReloadUnit app.service replace"}, []string{"systemctl reload app.service"}, false},
+		{"reset-failed", "app.service", nil, []string{"systemctl reset-failed app.service"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.verb, func(t *testing.T) {
+			var units []string
+			if tt.unit != "" {
+				units = []string{tt.unit}
+			}
+			err := m.systemctl(ctx, tt.verb, units...)
+			if (err != nil) != tt.err {
+				t.Errorf("systemctl(%s) returned %v", tt.verb, err)
+			}
+			if calls := f.takeCalls(); !reflect.DeepEqual(calls, tt.calls) {
+				t.Errorf("systemctl(%s) called %q, want %q", tt.verb, calls, tt.calls)
+			}
+			if !reflect.DeepEqual(rec.commands, tt.commands) {
+				t.Errorf("systemctl(%s) ran %q, want %q", tt.verb, rec.commands, tt.commands)
+			}
+			rec.commands = nil
+		})
+	}
+	if m.broken {
+		t.Error("replies of the bus marked the connection broken")
+	}
+}
+
+func TestBusManagerProperties(t *testing.T) {
+	m, _, rec := newTestBusManager(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	props, err := m.properties(ctx, "app.service", "ActiveState", "CanReload", "MainPID", "ExecMainStatus", "Environment", "Unknown")
+	want := map[string]string{
+		"ActiveState":    "active",
+		"CanReload":      "no",
+		"MainPID":        "1234",
+		"ExecMainStatus": "0",
+		"Environment":    "A=1 B=2",
+	}
+	if err != nil || !reflect.DeepEqual(props, want) {
+		t.Errorf("properties() = %v, %v, want %v", props, err, want)
+	}
+
+	if _, err := m.properties(ctx, "missing.service", "ActiveState"); !errors.Is(err, ErrNotInstalled) {
+		t.Errorf("properties() of missing unit returned %v, want %v", err, ErrNotInstalled)
+	}
+	if len(rec.commands) > 0 {
+		t.Errorf("properties() ran %q", rec.commands)
+	}
+}
+
+func TestBusManagerBroken(t *testing.T) {
+	m, f, rec := newTestBusManager(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	m.bus.Close()
+	if err := m.systemctl(ctx, "start", "app.service"); err != nil {
+		t.Errorf("systemctl(start) returned %v", err)
+	}
+	if !m.broken {
+		t.Error("failed connection is not marked broken")
+	}
+	props, err := m.properties(ctx, "app.service", "ActiveState")
+	if err != nil || props["ActiveState"] != "inactive" {
+		t.Errorf("properties() = %v, %v", props, err)
+	}
+	want := []string{"systemctl start app.service", "systemctl show -p ActiveState app.service"}
+	if !reflect.DeepEqual(rec.commands, want) {
+		t.Errorf("ran %q, want %q", rec.commands, want)
+	}
+	if calls := f.takeCalls(); len(calls) > 0 {
+		t.Errorf("broken manager called %q", calls)
+	}
+}
@@ -1,118 +1,363 @@
 package supervisor
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
 	"os"
-	"os/exec"
+	"os/user"
 	"path"
-	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
+	"time"
 )
 
+const systemdBackend = "systemd"
+
 type systemD struct {
-	name         string
-	cmd          string
-	description  string
-	dependencies []string
-	workingDir   string
-	logFile      string
-	environ      map[string]string
-	restart      string
-	restartSec   string
+	cfg ServiceConfig
 }
 
 func (s *systemD) Status() (string, error) {
-	if ok, err := isRoot(); !ok {
-		return undefined, err
+	return s.StatusContext(context.Background())
+}
+
+func (s *systemD) StatusContext(ctx context.Context) (string, error) {
+	status, err := statusString(s.InspectContext(ctx))
+	return status, s.opError("status", err)
+}
+
+func (s *systemD) Inspect() (StatusInfo, error) {
+	return s.InspectContext(context.Background())
+}
+
+func (s *systemD) InspectContext(ctx context.Context) (StatusInfo, error) {
+	info := StatusInfo{Backend: systemdBackend, PID: -1}
+	if ok, err := s.privileged(); !ok {
+		return info, s.opError("status", err)
 	}
 	if !s.IsInstalled() {
-		return undefined, errNotInstalled
+		info.State = StateNotInstalled
+		return info, nil
 	}
-	if pid, r := s.isRunning(); r {
-		return running + "(pid: " + strconv.Itoa(pid) + ")", nil
+	props, err := s.show(ctx, "MainPID", "ActiveState", "ExecMainStatus")
+	if err != nil {
+		return info, s.opError("status", err)
 	}
-	return stopped, nil
+	info.State = systemdState(props["ActiveState"])
+	if pid, err := strconv.Atoi(props["MainPID"]); err == nil && pid > 0 {
+		info.PID = pid
+		info.StartedAt = processStartTime(pid)
+	}
+	info.ExitCode, _ = strconv.Atoi(props["ExecMainStatus"])
+	return info, nil
 }
 
 func (s *systemD) Restart() (string, error) {
-	if err := exec.Command("systemctl", "restart", s.ServiceName()).Run(); err != nil {
-		return startFailed, err
+	return s.RestartContext(context.Background())
+}
+
+func (s *systemD) RestartContext(ctx context.Context) (string, error) {
+	if err := s.systemctl(ctx, "restart", s.ServiceName()); err != nil {
+		return startFailed, s.opError("restart", err)
 	}
 	return "restarting", nil
 }
 
-func (s *systemD) UpdateEnviron(env map[string]string) (string, error) {
+func (s *systemD) Reload() (string, error) {
+	return s.ReloadContext(context.Background())
+}
+
+func (s *systemD) ReloadContext(ctx context.Context) (string, error) {
+	if ok, err := s.privileged(); !ok {
+		return reloadFailed, s.opError("reload", err)
+	}
+	if err := s.systemctl(ctx, "reload", s.ServiceName()); err != nil {
+		return reloadFailed, s.opError("reload", err)
+	}
+	return "reloading", nil
+}
 
-	if err := exec.Command("systemctl", "daemon-reload").Run(); err != nil {
-		return updateFailed, err
+func (s *systemD) Signal(sig syscall.Signal) error {
+	return s.SignalContext(context.Background(), sig)
+}
+
+func (s *systemD) SignalContext(ctx context.Context, sig syscall.Signal) error {
+	pid, err := s.pid(ctx)
+	if err == nil {
+		err = s.cfg.signal(ctx, pid, sig)
 	}
+	return s.opError("signal", err)
+}
 
-	if err := exec.Command("systemctl", "enable", s.ServiceName()).Run(); err != nil {
-		return updateFailed, err
+func (s *systemD) UpdateEnviron(env map[string]string) (string, error) {
+	p, err := s.plan(OpUpdateEnviron, nil)
+	if err != nil {
+		return updateFailed, s.opError("update", err)
+	}
+	if err := s.apply(context.Background(), p); err != nil {
+		return updateFailed, s.opError("update", err)
 	}
 
 	return "updated", nil
 }
 
 func (s *systemD) Start() (string, error) {
+	return s.StartContext(context.Background())
+}
+
+func (s *systemD) StartContext(ctx context.Context) (string, error) {
 	//check if calling user is root
-	if ok, err := isRoot(); !ok {
-		return startFailed, err
+	if ok, err := s.privileged(); !ok {
+		return startFailed, s.opError("start", err)
 	}
 	//start app via systemctl
-	if err := exec.Command("systemctl", "start", s.ServiceName()).Run(); err != nil {
-		return startFailed, err
+	if err := s.systemctl(ctx, "start", s.ServiceName()); err != nil {
+		return startFailed, s.opError("start", err)
 	}
 
 	return "starting", nil
 }
 
 func (s *systemD) Stop() (string, error) {
+	return s.StopContext(context.Background())
+}
+
+func (s *systemD) StopContext(ctx context.Context) (string, error) {
 	//check if calling user is root
-	if ok, err := isRoot(); !ok {
-		return stopFailed, err
+	if ok, err := s.privileged(); !ok {
+		return stopFailed, s.opError("stop", err)
 	}
-	//start app via systemctl
-	if err := exec.Command("systemctl", "stop", s.ServiceName()).Run(); err != nil {
-		return stopFailed, err
+	//stop app via systemctl
+	if err := s.systemctl(ctx, "stop", s.ServiceName()); err != nil {
+		return stopFailed, s.opError("stop", err)
 	}
 
 	return "stopping", nil
 }
 
 func (s *systemD) Install(args ...string) (string, error) {
+	return s.InstallContext(context.Background(), args...)
+}
+
+func (s *systemD) InstallContext(ctx context.Context, args ...string) (string, error) {
+	//check if calling user is root
+	if ok, err := s.privileged(); !ok {
+		return "Failed to install ", s.opError("install", err)
+	}
+	p, err := s.plan(OpInstall, args)
+	if err != nil {
+		return installFailed, s.opError("install", err)
+	}
+	if err := s.apply(ctx, p); err != nil {
+		return installFailed, s.opError("install", err)
+	}
+
+	return "installed", nil
+}
+
+func (s *systemD) Remove() (string, error) {
+	return s.RemoveContext(context.Background())
+}
+
+func (s *systemD) RemoveContext(ctx context.Context) (string, error) {
 	//check if calling user is root
-	if ok, err := isRoot(); !ok {
-		return "Failed to install ", err
+	if ok, err := s.privileged(); !ok {
+		return removeFailed, s.opError("remove", err)
+	}
+	p, err := s.plan(OpRemove, nil)
+	if err != nil {
+		return removeFailed, s.opError("remove", err)
+	}
+	if err := s.apply(ctx, p); err != nil {
+		return removeFailed, s.opError("remove", err)
+	}
+
+	return removed, nil
+}
+
+func (s *systemD) Enable() (string, error) {
+	return s.EnableContext(context.Background())
+}
+
+func (s *systemD) EnableContext(ctx context.Context) (string, error) {
+	return s.switchBoot(ctx, OpEnable, enabled, enableFailed)
+}
+
+func (s *systemD) Disable() (string, error) {
+	return s.DisableContext(context.Background())
+}
+
+func (s *systemD) DisableContext(ctx context.Context) (string, error) {
+	return s.switchBoot(ctx, OpDisable, disabled, disableFailed)
+}
+
+// switchBoot applies OpEnable or OpDisable
+func (s *systemD) switchBoot(ctx context.Context, op Operation, done, failed string) (string, error) {
+	if ok, err := s.privileged(); !ok {
+		return failed, s.opError(string(op), err)
+	}
+	p, err := s.plan(op, nil)
+	if err != nil {
+		return failed, s.opError(string(op), err)
 	}
-	//check if app has a service unit file
-	if s.IsInstalled() {
-		return installFailed, errAlreadyInstalled
+	if err := s.apply(ctx, p); err != nil {
+		return failed, s.opError(string(op), err)
 	}
+	return done, nil
+}
 
-	file, err := os.Create(s.unitFile())
+func (s *systemD) IsEnabled() (bool, error) {
+	return s.IsEnabledContext(context.Background())
+}
+
+// IsEnabledContext checks UnitFileState of the unit, the WantedBy link
+// of offline installs
+func (s *systemD) IsEnabledContext(ctx context.Context) (bool, error) {
+	if !s.IsInstalled() {
+		return false, s.opError("status", ErrNotInstalled)
+	}
+	if s.cfg.offline() {
+		_, err := os.Lstat(s.cfg.path(s.wantsLink()))
+		return err == nil, nil
+	}
+	props, err := s.show(ctx, "UnitFileState")
 	if err != nil {
-		return installFailed, err
+		return false, s.opError("status", err)
 	}
-	defer file.Close()
+	return props["UnitFileState"] == "enabled", nil
+}
+
+// Plan returns side effects of op without applying them
+func (s *systemD) Plan(op Operation, args ...string) (*Plan, error) {
+	p, err := s.plan(op, args)
+	return p, s.opError(string(op), err)
+}
 
+func (s *systemD) plan(op Operation, args []string) (*Plan, error) {
+	p := s.cfg.newPlan(op, systemdBackend)
+	switch op {
+	case OpInstall:
+		//check if app has a service unit file
+		if s.IsInstalled() {
+			return nil, ErrAlreadyInstalled
+		}
+		if err := s.cfg.planAccount(p, shadowTools); err != nil {
+			return nil, err
+		}
+		unit, err := s.render(args)
+		if err != nil {
+			return nil, err
+		}
+		if s.cfg.Scope == ScopeUser {
+			// unlike /etc/systemd/system it may not exist yet
+			p.mkdir(s.unitDir(), 0755)
+		}
+		p.writeFile(s.unitFile(), 0644, unit)
+		if s.cfg.Scope == ScopeUser && s.cfg.Linger {
+			if err := s.linger(p); err != nil {
+				return nil, err
+			}
+		}
+		if s.cfg.offline() {
+			p.mkdir(path.Dir(s.wantsLink()), 0755)
+			p.symlink(s.unitFile(), s.wantsLink(), false)
+			break
+		}
+		p.command(false, "systemctl", s.systemctlArgs("daemon-reload")...)
+		p.command(false, "systemctl", s.systemctlArgs("enable", s.ServiceName())...)
+	case OpRemove:
+		//check if app has a service unit file
+		if !s.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		if s.cfg.offline() {
+			p.remove(s.wantsLink(), true)
+			p.remove(s.unitFile(), false)
+			break
+		}
+		p.command(false, "systemctl", s.systemctlArgs("disable", s.ServiceName())...)
+		p.remove(s.unitFile(), false)
+		p.command(false, "systemctl", s.systemctlArgs("daemon-reload")...)
+	case OpEnable:
+		if !s.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		if s.cfg.offline() {
+			p.mkdir(path.Dir(s.wantsLink()), 0755)
+			// the link may exist already
+			p.symlink(s.unitFile(), s.wantsLink(), true)
+			break
+		}
+		p.command(false, "systemctl", s.systemctlArgs("enable", s.ServiceName())...)
+	case OpDisable:
+		if !s.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		if s.cfg.offline() {
+			p.remove(s.wantsLink(), true)
+			break
+		}
+		p.command(false, "systemctl", s.systemctlArgs("disable", s.ServiceName())...)
+	case OpUpdateEnviron:
+		if s.cfg.offline() {
+			// unit is read on boot
+			break
+		}
+		p.command(false, "systemctl", s.systemctlArgs("daemon-reload")...)
+		p.command(false, "systemctl", s.systemctlArgs("enable", s.ServiceName())...)
+	case opReconcile:
+		unit, err := s.render(args)
+		if err != nil {
+			return nil, err
+		}
+		p.writeFile(s.unitFile(), 0644, unit)
+		if !s.cfg.offline() {
+			p.command(false, "systemctl", s.systemctlArgs("daemon-reload")...)
+		}
+	default:
+		return nil, ErrNotSupported
+	}
+	return p, nil
+}
+
+// render returns unit file content
+func (s *systemD) render(args []string) ([]byte, error) {
 	t, err := template.New("systemdConfig").Parse(systemDConfig)
 	if err != nil {
-		return installFailed, err
+		return nil, err
+	}
+	hardening, err := s.cfg.Hardening.effective()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.cfg.RestartPolicy.check(systemdBackend, true); err != nil {
+		return nil, err
+	}
+	stop, err := s.stop()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.cfg.Hooks.check(systemdBackend, true, true); err != nil {
+		return nil, err
 	}
+	preStart, hooks := s.cfg.Hooks.systemd()
 	var env string
-	envFile := path.Join(s.workingDir, s.name+".env")
-	if _, err := os.Stat(envFile); os.IsNotExist(err) {
+	envFile := path.Join(s.cfg.WorkingDir, s.cfg.Name+".env")
+	if _, err := os.Stat(s.cfg.path(envFile)); os.IsNotExist(err) {
 		envFile = ""
 	}
 
-	if s.environ != nil && envFile == "" {
-		environ := mapToSlice(s.environ)
+	if s.cfg.Environ != nil && envFile == "" {
+		environ := mapToSlice(s.cfg.Environ)
 		env = environSystemd(environ)
 	}
+	var buf bytes.Buffer
 	if err := t.Execute(
-		file,
+		&buf,
 		&struct {
 			Name         string
 			Cmd          string
@@ -125,130 +370,339 @@ func (s *systemD) Install(args ...string) (string, error) {
 			Restart      string
 			RestartSec   string
 			WorkingDir   string
+			Marker       string
+			WantedBy     string
+			UserScope    bool
+			User         string
+			Group        string
+			Groups       string
+			Limits       string
+			Hardening    string
+			StartLimit   string
+			Stop         string
+			PreStart     string
+			Hooks        string
+			Reload       bool
 		}{
-			Name:         s.name,
-			Cmd:          s.cmd,
-			Description:  s.description,
-			Dependencies: strings.Join(s.dependencies, " "),
-			Args:         strings.Join(args, " "),
+			Name:         s.cfg.Name,
+			Cmd:          s.cfg.Cmd,
+			Description:  s.cfg.Description,
+			Dependencies: strings.Join(s.cfg.Dependencies, " "),
+			Args:         strings.Join(s.cfg.args(args), " "),
 			EnVar:        env,
 			EnvFile:      envFile,
-			Restart:      s.restart,
-			WorkingDir:   s.workingDir,
-			LogFile:      s.logFile,
-			RestartSec:   s.restartSec,
+			Restart:      s.cfg.Restart,
+			WorkingDir:   s.cfg.WorkingDir,
+			LogFile:      s.cfg.LogFile,
+			RestartSec:   s.cfg.RestartSec,
+			Marker:       s.cfg.marker(s.unitFile()).comment("# ", ""),
+			WantedBy:     s.wantedBy(),
+			UserScope:    s.cfg.Scope == ScopeUser,
+			User:         s.cfg.User,
+			Group:        s.cfg.Group,
+			Groups:       strings.Join(s.cfg.SupplementaryGroups, " "),
+			Limits:       s.cfg.Limits.systemd(),
+			Hardening:    hardening.directives(),
+			StartLimit:   s.cfg.RestartPolicy.startLimit(),
+			Stop:         stop,
+			PreStart:     preStart,
+			Hooks:        hooks,
+			Reload:       len(s.cfg.Hooks.Reload) > 0,
 		},
 	); err != nil {
-		return installFailed, err
+		return nil, err
 	}
+	return buf.Bytes(), nil
+}
 
-	if err := exec.Command("systemctl", "daemon-reload").Run(); err != nil {
-		return installFailed, err
-	}
+func (s *systemD) PID() (int, error) {
+	return s.pid(context.Background())
+}
+
+func (s *systemD) unitFile() string {
+	return path.Join(s.unitDir(), s.ServiceName())
+}
 
-	if err := exec.Command("systemctl", "enable", s.ServiceName()).Run(); err != nil {
-		return installFailed, err
+// unitDir is the directory of unit files of the scope
+func (s *systemD) unitDir() string {
+	if s.cfg.Scope == ScopeUser {
+		return path.Join(userConfigDir(), "systemd/user")
 	}
+	return "/etc/systemd/system"
+}
 
-	return "installed", nil
+func (s *systemD) serviceDir() (string, string) {
+	return s.unitDir(), ".service"
 }
 
-func (s *systemD) Remove() (string, error) {
-	//check if calling user is root
-	if ok, err := isRoot(); !ok {
-		return removeFailed, err
-	}
-	//check if app has a service unit file
-	if !s.IsInstalled() {
-		return removeFailed, errNotInstalled
+// wantedBy is the target starting the service on boot or login
+func (s *systemD) wantedBy() string {
+	if s.cfg.Scope == ScopeUser {
+		return "default.target"
 	}
+	return "multi-user.target"
+}
 
-	if err := exec.Command("systemctl", "disable", s.ServiceName()).Run(); err != nil {
-		return removeFailed, err
-	}
+// wantsLink is the symlink systemctl enable creates for WantedBy
+func (s *systemD) wantsLink() string {
+	return path.Join(s.unitDir(), s.wantedBy()+".wants", s.ServiceName())
+}
 
-	if err := os.Remove(s.unitFile()); err != nil {
-		return removeFailed, err
-	}
+func (s *systemD) supportsScope(scope Scope) bool {
+	return scope == ScopeUser
+}
 
-	if err := exec.Command("systemctl", "daemon-reload").Run(); err != nil {
-		return updateFailed, err
+// systemctlArgs returns arguments of systemctl verb in the scope
+func (s *systemD) systemctlArgs(verb string, units ...string) []string {
+	args := []string{verb}
+	if s.cfg.Scope == ScopeUser {
+		args = []string{"--user", verb}
 	}
-
-	return removed, nil
+	return append(args, units...)
 }
 
-func (s *systemD) PID() (int, error) {
-	return s.pid()
+// privileged checks the caller may manage units of the scope
+func (s *systemD) privileged() (bool, error) {
+	if s.cfg.Scope == ScopeUser {
+		return true, nil
+	}
+	return s.cfg.privileged()
 }
 
-func (s *systemD) unitFile() string {
-	return "/etc/systemd/system/" + s.name + ".service"
+// linger plans lingering of the calling user. Offline installs create
+// the file loginctl enable-linger would create for User, the calling
+// user does not log in to the image.
+func (s *systemD) linger(p *Plan) error {
+	if s.cfg.offline() {
+		if s.cfg.User == "" {
+			return errors.New("lingering on offline installs requires User")
+		}
+		p.mkdir("/var/lib/systemd/linger", 0755)
+		p.writeFile("/var/lib/systemd/linger/"+s.cfg.User, 0644, nil)
+		return nil
+	}
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+	p.command(false, "loginctl", "enable-linger", u.Username)
+	return nil
 }
 
 func (s *systemD) ServiceName() string {
-	return s.name + ".service"
+	return s.cfg.Name + ".service"
+}
+
+// opError annotates err with the operation and service
+func (s *systemD) opError(op string, err error) error {
+	return newOpError(op, s.cfg.Name, systemdBackend, err)
 }
 
 func (s *systemD) IsInstalled() bool {
-	if _, err := os.Stat(s.unitFile()); err == nil {
+	if _, err := os.Stat(s.cfg.path(s.unitFile())); err == nil {
 		return true
 	}
 	return false
 }
 
-func (s *systemD) isRunning() (int, bool) {
-	pid, _ := s.pid()
-	if pid > 0 {
-		return pid, true
+func (s *systemD) pid(ctx context.Context) (int, error) {
+	props, err := s.show(ctx, "MainPID", "ActiveState")
+	if err == nil && props["ActiveState"] == "active" {
+		if pid, err := strconv.Atoi(props["MainPID"]); err == nil && pid > 0 {
+			return pid, nil
+		}
+		return -1, nil
 	}
-	return pid, false
+	return -1, ErrNotRunning
 }
 
-func (s *systemD) pid() (int, error) {
-	out, err := exec.Command("systemctl", "status", s.ServiceName()).Output()
-	if err == nil {
-		if matched, err := regexp.MatchString("Active: active", string(out)); err == nil && matched {
-			reg := regexp.MustCompile("Main PID: ([0-9]+)")
-			data := reg.FindStringSubmatch(string(out))
-			if len(data) > 1 {
-				return strconv.Atoi(data[1])
-			}
-			return -1, nil
+// show reads unit properties through the unit manager
+func (s *systemD) show(ctx context.Context, props ...string) (map[string]string, error) {
+	m := s.manager(ctx)
+	defer m.Close()
+	return m.properties(ctx, s.ServiceName(), props...)
+}
+
+// parseSystemctlShow parses Key=Value lines of systemctl show
+func parseSystemctlShow(out []byte) map[string]string {
+	props := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		if i := strings.IndexByte(line, '='); i > 0 {
+			props[line[:i]] = strings.TrimSpace(line[i+1:])
 		}
 	}
-	return -1, errNotRunning
+	return props
+}
+
+// systemdState maps unit ActiveState to State
+func systemdState(active string) State {
+	switch active {
+	case "active", "reloading":
+		return StateRunning
+	case "activating", "deactivating":
+		return StateActivating
+	case "inactive":
+		return StateStopped
+	case "failed":
+		return StateFailed
+	}
+	return StateUnknown
+}
+
+// userConfigDir returns XDG config directory of the calling user
+func userConfigDir() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return dir
+	}
+	if u, err := user.Current(); err == nil {
+		return path.Join(u.HomeDir, ".config")
+	}
+	return ".config"
+}
+
+// systemdKillModes maps KillMode to values of KillMode=
+var systemdKillModes = map[KillMode]string{
+	KillProcess: "process",
+	KillGroup:   "control-group",
 }
 
-func isRoot() (bool, error) {
-	if output, err := exec.Command("id", "-g").Output(); err == nil {
-		if gid, err := strconv.ParseUint(strings.TrimSpace(string(output)), 10, 32); err == nil {
-			if gid == 0 {
-				return true, nil
+// stop returns directives stopping the service, systemd escalates to
+// SIGKILL on TimeoutStopSec by itself
+func (s *systemD) stop() (string, error) {
+	stop, _, err := s.cfg.stopping()
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	if s.cfg.StopSignal != 0 {
+		lines = append(lines, "KillSignal=SIG"+stop.signal)
+	}
+	if s.cfg.StopTimeout != 0 {
+		lines = append(lines, "TimeoutStopSec="+strconv.Itoa(seconds(s.cfg.StopTimeout)))
+	}
+	if s.cfg.KillMode != "" {
+		lines = append(lines, "KillMode="+systemdKillModes[stop.mode])
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// load parses the installed unit file
+func (s *systemD) load() (ServiceConfig, error) {
+	content, err := ioutil.ReadFile(s.cfg.path(s.unitFile()))
+	if err != nil {
+		return s.cfg, err
+	}
+	cfg := s.cfg
+	cfg.Limits = Limits{}
+	// a preset is loaded as the directives it sets
+	cfg.Hardening = Hardening{}
+	cfg.RestartPolicy = RestartPolicy{}
+	cfg.StopSignal, cfg.StopTimeout, cfg.KillMode = 0, 0, ""
+	cfg.Hooks = Hooks{}
+	for _, line := range strings.Split(string(content), "\n") {
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			continue
+		}
+		key, value := line[:i], line[i+1:]
+		switch key {
+		case "Description":
+			cfg.Description = value
+		case "Requires":
+			cfg.Dependencies = strings.Fields(value)
+		case "ExecStart":
+			if cmdline, ok := between(value, "/bin/sh -c '", " 2>&1'"); ok {
+				// units written before the shell exec'd the command lack exec
+				cmdline, cfg.LogFile = splitLogRedirect(strings.TrimPrefix(cmdline, "exec "), " >>")
+				cfg.Cmd, cfg.Args = splitCommandLine(cmdline)
+			}
+		case "WorkingDirectory":
+			cfg.WorkingDir = value
+		case "User":
+			cfg.User = value
+		case "Group":
+			cfg.Group = value
+		case "SupplementaryGroups":
+			cfg.SupplementaryGroups = strings.Fields(value)
+		case "Environment":
+			cfg.Environ = parseQuotedEnv(value)
+		case "Restart":
+			cfg.Restart = value
+			for mode, v := range systemdRestart {
+				if v == value {
+					cfg.RestartPolicy.Mode = mode
+				}
+			}
+		case "RestartSec":
+			cfg.RestartSec = value
+			if v, err := strconv.Atoi(value); err == nil {
+				cfg.RestartPolicy.Delay = time.Duration(v) * time.Second
+			}
+		case "StartLimitIntervalSec":
+			if v, err := strconv.Atoi(value); err == nil {
+				cfg.RestartPolicy.Window = time.Duration(v) * time.Second
+			}
+		case "StartLimitBurst":
+			cfg.RestartPolicy.MaxAttempts, _ = strconv.Atoi(value)
+		case "KillSignal":
+			cfg.StopSignal, _ = parseSignal(value)
+		case "TimeoutStopSec":
+			if v, err := strconv.Atoi(value); err == nil {
+				cfg.StopTimeout = time.Duration(v) * time.Second
+			}
+		case "KillMode":
+			for mode, v := range systemdKillModes {
+				if v == value {
+					cfg.KillMode = mode
+				}
+			}
+		case "StartLimitAction":
+			if value == "reboot" {
+				cfg.RestartPolicy.OnLimit = LimitReboot
+			}
+		default:
+			if cfg.Hooks.parseSystemd(key, value) {
+				break
+			}
+			if !cfg.Hardening.parse(key, value) {
+				cfg.Limits.parse(limitSystemd, key, value)
 			}
-			return false, errPermissionDenied
 		}
 	}
-	return false, errOSNotSupported
+	if m, ok := parseMarker(content); ok {
+		cfg.Labels = m.Labels
+	}
+	return cfg, nil
 }
 
-var systemDConfig = `[Unit]
+var systemDConfig = `{{.Marker}}
+[Unit]
 Description={{.Description}}
 Requires={{.Dependencies}}
 After={{.Dependencies}}
-
+{{if .StartLimit}}{{.StartLimit}}
+{{end}}
 [Service]
 CPUAccounting=yes
 MemoryAccounting=yes
-PIDFile=/var/run/{{.Name}}.pid
+{{if .Limits}}{{.Limits}}
+{{end}}{{if not .UserScope}}PIDFile=/var/run/{{.Name}}.pid
 ExecStartPre=/bin/rm -f /var/run/{{.Name}}.pid
-ExecStart=/bin/sh -c '{{.Cmd}} {{.Args}} >>{{.LogFile}} 2>&1'
-WorkingDirectory={{.WorkingDir}}
-Environment={{.EnVar}}
+{{end}}{{if .PreStart}}{{.PreStart}}
+{{end}}ExecStart=/bin/sh -c 'exec {{.Cmd}} {{.Args}} >>{{.LogFile}} 2>&1'
+{{if .Hooks}}{{.Hooks}}
+{{end}}{{if not .Reload}}ExecReload=/bin/kill -HUP $MAINPID
+{{end}}WorkingDirectory={{.WorkingDir}}
+{{if and .User (not .UserScope)}}User={{.User}}
+{{end}}{{if .Group}}Group={{.Group}}
+{{end}}{{if .Groups}}SupplementaryGroups={{.Groups}}
+{{end}}Environment={{.EnVar}}
 {{if .EnvFile}}EnvironmentFile={{.EnvFile}}{{end}}
 Restart={{.Restart}}
 RestartSec={{.RestartSec}}
-
+{{if .Stop}}{{.Stop}}
+{{end}}{{if .Hardening}}{{.Hardening}}
+{{end}}
 [Install]
-WantedBy=multi-user.target
+WantedBy={{.WantedBy}}
 `
@@ -0,0 +1,166 @@
+package supervisor
+
+import (
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseSystemctlShow(t *testing.T) {
+	tests := []struct {
+		name  string
+		out   string
+		props map[string]string
+	}{
+		{"properties", "MainPID=1234\nActiveState=active\nSubState=running\nExecMainStatus=0\n", map[string]string{
+			"MainPID":        "1234",
+			"ActiveState":    "active",
+			"SubState":       "running",
+			"ExecMainStatus": "0",
+		}},
+		{"value with equal sign", "Environment=A=1 B=2\n", map[string]string{"Environment": "A=1 B=2"}},
+		{"empty value", "ActiveEnterTimestamp=\n", map[string]string{"ActiveEnterTimestamp": ""}},
+		{"trailing space", "UnitFileState=enabled \r\n", map[string]string{"UnitFileState": "enabled"}},
+		{"no property", "\n=value\ngarbage\n", map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if props := parseSystemctlShow([]byte(tt.out)); !reflect.DeepEqual(props, tt.props) {
+				t.Errorf("parseSystemctlShow(%q) = %v, want %v", tt.out, props, tt.props)
+			}
+		})
+	}
+}
+
+// renderSystemd renders the unit file of cfg
+func renderSystemd(t *testing.T, cfg ServiceConfig) string {
+	unit, err := (&systemD{cfg: cfg}).render(nil)
+	if err != nil {
+		t.Fatalf("render() failed: %v", err)
+	}
+	return string(unit)
+}
+
+func TestSystemdExecStart(t *testing.T) {
+	unit := renderSystemd(t, ServiceConfig{Name: "app", Cmd: "/usr/bin/app", Args: []string{"-v"}, WorkingDir: "/opt/app", LogFile: "/var/log/app.log"})
+	// the shell execs the command so MainPID, signalled by reload and stop,
+	// is the command itself
+	want := "ExecStart=/bin/sh -c 'exec /usr/bin/app -v >>/var/log/app.log 2>&1'\n"
+	if !strings.Contains(unit, want) {
+		t.Errorf("unit lacks %q:\n%s", want, unit)
+	}
+}
+
+func TestSystemdStop(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  ServiceConfig
+		want []string
+	}{
+		{"default", ServiceConfig{}, nil},
+		// the signal reaches the command as the shell execs it
+		{"process", ServiceConfig{StopSignal: syscall.SIGINT, StopTimeout: 30 * time.Second, KillMode: KillProcess}, []string{
+			"ExecStart=/bin/sh -c 'exec /usr/bin/app  >>/var/log/app.log 2>&1'",
+			"KillSignal=SIGINT",
+			"TimeoutStopSec=30",
+			"KillMode=process",
+		}},
+		{"group", ServiceConfig{StopSignal: syscall.SIGQUIT, KillMode: KillGroup}, []string{
+			"KillSignal=SIGQUIT",
+			"KillMode=control-group",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Name, cfg.Cmd, cfg.WorkingDir, cfg.LogFile = "app", "/usr/bin/app", "/opt/app", "/var/log/app.log"
+			unit := renderSystemd(t, cfg)
+			for _, line := range tt.want {
+				if !strings.Contains(unit, line+"\n") {
+					t.Errorf("unit lacks %q:\n%s", line, unit)
+				}
+			}
+			if len(tt.want) == 0 && (strings.Contains(unit, "KillSignal=") || strings.Contains(unit, "KillMode=")) {
+				t.Errorf("unit of default stop sets kill directives:\n%s", unit)
+			}
+		})
+	}
+}
+
+func TestSystemdUserScope(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  ServiceConfig
+		ok   bool
+	}{
+		{"calling user", ServiceConfig{}, true},
+		{"user", ServiceConfig{User: "pi"}, false},
+		{"group", ServiceConfig{Group: "pi"}, false},
+		{"supplementary groups", ServiceConfig{SupplementaryGroups: []string{"dialout"}}, false},
+		// the image has no calling user, User owns the scope
+		{"offline user", ServiceConfig{User: "pi", RootDir: "/mnt"}, true},
+		{"offline group", ServiceConfig{User: "pi", Group: "pi", RootDir: "/mnt"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Name, cfg.Cmd, cfg.Scope = "app", "/usr/bin/app", ScopeUser
+			_, err := NewServiceFor(systemdBackend, cfg)
+			if (err == nil) != tt.ok {
+				t.Errorf("NewServiceFor() returned %v", err)
+			}
+		})
+	}
+}
+
+func TestSystemdLingerOffline(t *testing.T) {
+	cfg := ServiceConfig{Name: "app", Cmd: "/usr/bin/app", Scope: ScopeUser, Linger: true, RootDir: "/mnt"}
+	if _, err := (&systemD{cfg: cfg}).Plan(OpInstall); err == nil {
+		t.Error("Plan(install) lingering offline without User succeeded")
+	}
+
+	cfg.User = "pi"
+	p, err := (&systemD{cfg: cfg}).Plan(OpInstall)
+	if err != nil {
+		t.Fatalf("Plan(install) failed: %v", err)
+	}
+	var linger bool
+	for _, f := range p.Files() {
+		if f.Path == "/mnt/var/lib/systemd/linger/pi" {
+			linger = true
+		}
+		if strings.Contains(string(f.Content), "User=") {
+			t.Errorf("user unit has User=:\n%s", f.Content)
+		}
+	}
+	if !linger {
+		t.Errorf("Plan(install) does not linger pi:\n%v", p)
+	}
+}
+
+func TestSystemdRoundTrip(t *testing.T) {
+	checkRoundTrip(t, ServiceConfig{
+		Backend:      systemdBackend,
+		Name:         "app",
+		Cmd:          "/usr/bin/app",
+		Args:         []string{"-v", "--port", "8080"},
+		Description:  "Test application",
+		WorkingDir:   "/opt/app",
+		LogFile:      "/var/log/app.log",
+		Dependencies: []string{"network-online.target", "redis.service"},
+		Environ:      map[string]string{"MODE": "production", "GREETING": "hello world"},
+		Labels:       map[string]string{"team": "edge"},
+		User:         "app",
+		Group:        "app",
+		Limits:       Limits{NOFILE: 4096, CPUQuota: 50},
+		// presets are loaded as the directives they set
+		Hardening:     Hardening{NoNewPrivileges: "yes", ProtectSystem: "strict", ReadWritePaths: []string{"/var/log"}, SystemCallFilter: []string{"@system-service"}},
+		RestartPolicy: RestartPolicy{Mode: RestartAlways, Delay: 5 * time.Second, MaxAttempts: 3, Window: time.Minute},
+		StopSignal:    syscall.SIGINT,
+		StopTimeout:   20 * time.Second,
+		KillMode:      KillGroup,
+		Hooks:         Hooks{PreStart: []string{"mkdir -p /run/app"}, PostStop: []string{"rm -rf /run/app"}},
+	})
+}
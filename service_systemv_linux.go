@@ -1,213 +1,549 @@
 package supervisor
 
 import (
+	"bytes"
+	"context"
+	"io/ioutil"
 	"os"
-	"os/exec"
-	"regexp"
-	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
 )
 
+const sysvBackend = "sysv"
+
 // systemV - standard record (struct) for linux systemV version of daemon package
 type systemV struct {
-	name         string
-	cmd          string
-	description  string
-	dependencies []string
-	workingDir   string
-	logFile      string
-	environ      map[string]string
+	cfg ServiceConfig
 }
 
 // Standard service path for systemV daemons
 func (l *systemV) servicePath() string {
-	return "/etc/init.d/" + l.name
+	return "/etc/init.d/" + l.cfg.Name
+}
+
+func (l *systemV) serviceDir() (string, string) {
+	return "/etc/init.d", ""
 }
 
 // Is a service installed
 func (l *systemV) IsInstalled() bool {
-	if _, err := os.Stat(l.servicePath()); err == nil {
+	if _, err := os.Stat(l.cfg.path(l.servicePath())); err == nil {
 		return true
 	}
 	return false
 }
 
+// pidFile is the pid file written by the init script
+func (l *systemV) pidFile() string {
+	return "/var/run/" + l.cfg.Name + ".pid"
+}
+
 // Check service is running
-func (l *systemV) checkRunning() (int, error) {
-	output, err := exec.Command("service", l.name, "status").Output()
+func (l *systemV) checkRunning(ctx context.Context) (int, error) {
+	state, err := l.state(ctx)
+	if err != nil {
+		return -1, err
+	}
+	if state != StateRunning {
+		return -1, ErrNotRunning
+	}
+	return readPidFile(l.pidFile()), nil
+}
+
+// state runs status action of the init script, see lsbState
+func (l *systemV) state(ctx context.Context) (State, error) {
+	_, err := l.cfg.output(ctx, "service", l.cfg.Name, "status")
+	code := exitCode(err)
+	if err != nil && code < 0 {
+		return StateUnknown, err
+	}
 	if err == nil {
-		if matched, err := regexp.MatchString(l.name, string(output)); err == nil && matched {
-			reg := regexp.MustCompile("pid  ([0-9]+)")
-			data := reg.FindStringSubmatch(string(output))
-			if len(data) > 1 {
-				return strconv.Atoi(data[1])
-			}
-			return -1, nil
-		}
+		code = 0
+	}
+	return lsbState(code), nil
+}
+
+// lsbState maps exit status of LSB init script status action to State
+func lsbState(code int) State {
+	switch code {
+	case 0:
+		return StateRunning
+	case 1, 2:
+		// program is dead and pid or lock file exists
+		return StateFailed
+	case 3:
+		return StateStopped
 	}
-	return -1, errNotRunning
+	return StateUnknown
 }
 
 func (l *systemV) PID() (int, error) {
-	return l.checkRunning()
+	return l.checkRunning(context.Background())
 }
 
 // Install the service
 func (l *systemV) Install(args ...string) (string, error) {
-	if ok, err := checkPrivileges(); !ok {
+	return l.InstallContext(context.Background(), args...)
+}
+
+// InstallContext installs the service, ctx bounds creation of the service
+// account and file changes made with commands run through Escalate
+func (l *systemV) InstallContext(ctx context.Context, args ...string) (string, error) {
+	if ok, err := l.cfg.privileged(); !ok {
+		return "", l.opError("install", err)
+	}
+
+	p, err := l.plan(OpInstall, args)
+	if err != nil {
+		return "", l.opError("install", err)
+	}
+	if err := l.cfg.apply(ctx, p); err != nil {
+		return "", l.opError("install", err)
+	}
+
+	return installed, nil
+}
+
+// Plan returns side effects of op without applying them
+func (l *systemV) Plan(op Operation, args ...string) (*Plan, error) {
+	p, err := l.plan(op, args)
+	return p, l.opError(string(op), err)
+}
+
+func (l *systemV) plan(op Operation, args []string) (*Plan, error) {
+	p := l.cfg.newPlan(op, sysvBackend)
+	switch op {
+	case OpInstall:
+		if l.IsInstalled() {
+			return nil, ErrAlreadyInstalled
+		}
+		if err := l.cfg.planAccount(p, shadowTools); err != nil {
+			return nil, err
+		}
+		script, err := l.render(args)
+		if err != nil {
+			return nil, err
+		}
+		p.writeFile(l.servicePath(), 0755, script)
+		for _, link := range append(l.rcLinks("S"), l.rcLinks("K")...) {
+			p.symlink(l.servicePath(), link, true)
+		}
+	case OpRemove:
+		if !l.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		p.remove(l.servicePath(), false)
+		for _, link := range append(l.rcLinks("S"), l.rcLinks("K")...) {
+			p.remove(link, true)
+		}
+	case OpEnable:
+		if !l.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		// links may exist already
+		for _, link := range append(l.rcLinks("S"), l.rcLinks("K")...) {
+			p.symlink(l.servicePath(), link, true)
+		}
+	case OpDisable:
+		if !l.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		// stop links are kept to stop the service on shutdown
+		for _, link := range l.rcLinks("S") {
+			p.remove(link, true)
+		}
+	case OpUpdateEnviron:
+		if !l.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		script, err := l.render(nil)
+		if err != nil {
+			return nil, err
+		}
+		p.writeFile(l.servicePath(), 0755, script)
+	case opReconcile:
+		script, err := l.render(args)
+		if err != nil {
+			return nil, err
+		}
+		p.writeFile(l.servicePath(), 0755, script)
+	default:
+		return nil, ErrNotSupported
+	}
+	return p, nil
+}
+
+// rcLinks returns start (S) links of runlevels 2 to 5 or stop (K) links
+// of runlevels 0, 1 and 6
+func (l *systemV) rcLinks(kind string) []string {
+	runlevels, priority := []string{"2", "3", "4", "5"}, "87"
+	if kind == "K" {
+		runlevels, priority = []string{"0", "1", "6"}, "17"
+	}
+	links := make([]string, len(runlevels))
+	for i, runlevel := range runlevels {
+		links[i] = "/etc/rc" + runlevel + ".d/" + kind + priority + l.cfg.Name
+	}
+	return links
+}
+
+// Enable the service at boot
+func (l *systemV) Enable() (string, error) {
+	return l.EnableContext(context.Background())
+}
+
+// EnableContext creates rc.d links of the init script, ctx bounds them
+// when they are made with commands run through Escalate
+func (l *systemV) EnableContext(ctx context.Context) (string, error) {
+	if err := l.switchBoot(ctx, OpEnable); err != nil {
 		return "", err
 	}
+	return enabled, nil
+}
+
+// Disable the service at boot
+func (l *systemV) Disable() (string, error) {
+	return l.DisableContext(context.Background())
+}
 
-	if l.IsInstalled() {
-		return "", errAlreadyInstalled
+// DisableContext removes start links of the init script, ctx bounds
+// removal made with commands run through Escalate
+func (l *systemV) DisableContext(ctx context.Context) (string, error) {
+	if err := l.switchBoot(ctx, OpDisable); err != nil {
+		return "", err
 	}
+	return disabled, nil
+}
 
-	file, err := os.Create(l.servicePath())
+// switchBoot applies OpEnable or OpDisable
+func (l *systemV) switchBoot(ctx context.Context, op Operation) error {
+	if ok, err := l.cfg.privileged(); !ok {
+		return l.opError(string(op), err)
+	}
+	p, err := l.plan(op, nil)
 	if err != nil {
-		return "", err
+		return l.opError(string(op), err)
+	}
+	return l.opError(string(op), l.cfg.apply(ctx, p))
+}
+
+func (l *systemV) IsEnabled() (bool, error) {
+	return l.IsEnabledContext(context.Background())
+}
+
+// IsEnabledContext checks start links of the init script
+func (l *systemV) IsEnabledContext(ctx context.Context) (bool, error) {
+	if !l.IsInstalled() {
+		return false, l.opError("status", ErrNotInstalled)
+	}
+	for _, link := range l.rcLinks("S") {
+		if _, err := os.Lstat(l.cfg.path(link)); err == nil {
+			return true, nil
+		}
 	}
-	defer file.Close()
+	return false, nil
+}
 
+// render returns init script content
+func (l *systemV) render(args []string) ([]byte, error) {
 	templ, err := template.New("systemVConfig").Parse(systemVConfig)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if err := l.cfg.systemdOnly(sysvBackend); err != nil {
+		return nil, err
+	}
+	if err := l.cfg.Limits.checkUlimit(sysvBackend); err != nil {
+		return nil, err
+	}
+	if err := l.cfg.RestartPolicy.check(sysvBackend, true); err != nil {
+		return nil, err
+	}
+	stop, stopSet, err := l.cfg.stopping()
+	if err != nil {
+		return nil, err
+	}
+	if err := l.cfg.Hooks.check(sysvBackend, false, true); err != nil {
+		return nil, err
+	}
+	var respawnLoop, stopScript string
+	if l.cfg.RestartPolicy.respawns() {
+		respawnLoop = l.cfg.RestartPolicy.respawnLoop(l.cfg.Cmd+" "+strings.Join(l.cfg.args(args), " "), l.cfg.LogFile, l.cfg.LogFile, stop)
+	}
+	if stopSet {
+		stopScript = stop.script("    ", "$pidfile", respawnLoop != "")
+	}
+	// bash starts the service in the background with its own process
+	// group and the stop signal not ignored, then prints its pid
+	launch := stop.resetIgnored(stop.setsid(false)+l.cfg.Cmd+" "+strings.Join(l.cfg.args(args), " ")) + " < /dev/null >> $stdoutlog 2>> $stderrlog"
+	if respawnLoop != "" {
+		launch = stop.resetIgnored(stop.setsid(true)+"/bin/bash -c '$respawn'") + " < /dev/null > /dev/null 2>&1"
 	}
 
+	var buf bytes.Buffer
 	if err := templ.Execute(
-		file,
+		&buf,
 		&struct {
 			Name, Description   string
+			Dependencies        []string
+			EnVar               string
 			WorkingDir, LogFile string
-			Args, Cmd           string
+			Args, Cmd, Marker   string
+			User, Chuid         string
+			Ulimits             string
+			RespawnLoop, Launch string
+			Stop                string
+			Hooks               Hooks
+			HookFuncs           string
 		}{
-			Name:        l.name,
-			Cmd:         l.cmd,
-			WorkingDir:  l.workingDir,
-			LogFile:     l.logFile,
-			Description: l.description,
-			Args:        strings.Join(args, " "),
+			Name:         l.cfg.Name,
+			Dependencies: l.cfg.Dependencies,
+			EnVar:        environProcd(mapToSlice(l.cfg.Environ)),
+			Cmd:          l.cfg.Cmd,
+			WorkingDir:   l.cfg.WorkingDir,
+			LogFile:      l.cfg.LogFile,
+			Description:  l.cfg.Description,
+			Args:         strings.Join(l.cfg.args(args), " "),
+			Marker:       l.cfg.marker(l.servicePath()).comment("# ", ""),
+			User:         l.cfg.User,
+			Chuid:        l.cfg.chuid(),
+			Ulimits:      l.cfg.Limits.ulimit("        "),
+			RespawnLoop:  respawnLoop,
+			Launch:       launch,
+			Stop:         stopScript,
+			Hooks:        l.cfg.Hooks,
+			HookFuncs:    l.cfg.Hooks.functions(hookScript, "    "),
 		},
 	); err != nil {
-		return "", err
-	}
-
-	if err := os.Chmod(l.servicePath(), 0755); err != nil {
-		return "", err
+		return nil, err
 	}
-
-	for _, i := range [...]string{"2", "3", "4", "5"} {
-		if err := os.Symlink(l.servicePath(), "/etc/rc"+i+".d/S87"+l.name); err != nil {
-			continue
-		}
-	}
-	for _, i := range [...]string{"0", "1", "6"} {
-		if err := os.Symlink(l.servicePath(), "/etc/rc"+i+".d/K17"+l.name); err != nil {
-			continue
-		}
-	}
-
-	return installed, nil
+	return buf.Bytes(), nil
 }
 
 func (l *systemV) ServiceName() string {
-	return l.name
+	return l.cfg.Name
+}
+
+// opError annotates err with the operation and service
+func (l *systemV) opError(op string, err error) error {
+	return newOpError(op, l.cfg.Name, sysvBackend, err)
 }
 
 // Remove the service
 func (l *systemV) Remove() (string, error) {
-	if ok, err := checkPrivileges(); !ok {
-		return "", err
-	}
-
-	if !l.IsInstalled() {
-		return "", errNotInstalled
-	}
+	return l.RemoveContext(context.Background())
+}
 
-	if err := os.Remove(l.servicePath()); err != nil {
-		return "", err
+// RemoveContext removes the service, ctx bounds file changes made with
+// commands run through Escalate
+func (l *systemV) RemoveContext(ctx context.Context) (string, error) {
+	if ok, err := l.cfg.privileged(); !ok {
+		return "", l.opError("remove", err)
 	}
 
-	for _, i := range [...]string{"2", "3", "4", "5"} {
-		if err := os.Remove("/etc/rc" + i + ".d/S87" + l.name); err != nil {
-			continue
-		}
+	p, err := l.plan(OpRemove, nil)
+	if err != nil {
+		return "", l.opError("remove", err)
 	}
-	for _, i := range [...]string{"0", "1", "6"} {
-		if err := os.Remove("/etc/rc" + i + ".d/K17" + l.name); err != nil {
-			continue
-		}
+	if err := l.cfg.apply(ctx, p); err != nil {
+		return "", l.opError("remove", err)
 	}
 
 	return removed, nil
 }
 
-func (l *systemV) isRunning() (int, bool) {
-	pid, err := l.checkRunning()
-	if err != nil {
-		return -1, false
-	}
-	if pid > 0 {
-		return pid, true
-	}
-	return pid, false
-}
-
 // Start the service
 func (l *systemV) Start() (string, error) {
-	if ok, err := checkPrivileges(); !ok {
-		return "", err
+	return l.StartContext(context.Background())
+}
+
+// StartContext starts the service
+func (l *systemV) StartContext(ctx context.Context) (string, error) {
+	if ok, err := l.cfg.privileged(); !ok {
+		return "", l.opError("start", err)
 	}
 	if !l.IsInstalled() {
-		return "", errNotInstalled
+		return "", l.opError("start", ErrNotInstalled)
 	}
-	if err := exec.Command("service", l.name, "start").Run(); err != nil {
-		return "", err
+	if err := l.cfg.run(ctx, "service", l.cfg.Name, "start"); err != nil {
+		return "", l.opError("start", err)
 	}
 	return started, nil
 }
 
 func (l *systemV) Restart() (string, error) {
-	if err := exec.Command("service", l.name, "restart").Run(); err != nil {
-		return "", err
+	return l.RestartContext(context.Background())
+}
+
+// RestartContext restarts the service
+func (l *systemV) RestartContext(ctx context.Context) (string, error) {
+	if err := l.cfg.run(ctx, "service", l.cfg.Name, "restart"); err != nil {
+		return "", l.opError("restart", err)
 	}
 	return restarted, nil
 }
 
+func (l *systemV) Reload() (string, error) {
+	return l.ReloadContext(context.Background())
+}
+
+// ReloadContext runs reload action of the init script
+func (l *systemV) ReloadContext(ctx context.Context) (string, error) {
+	if ok, err := l.cfg.privileged(); !ok {
+		return "", l.opError("reload", err)
+	}
+	if !l.IsInstalled() {
+		return "", l.opError("reload", ErrNotInstalled)
+	}
+	if err := l.cfg.run(ctx, "service", l.cfg.Name, "reload"); err != nil {
+		return "", l.opError("reload", err)
+	}
+	return reloaded, nil
+}
+
+func (l *systemV) Signal(sig syscall.Signal) error {
+	return l.SignalContext(context.Background(), sig)
+}
+
+// SignalContext delivers sig to the process of the pid file
+func (l *systemV) SignalContext(ctx context.Context, sig syscall.Signal) error {
+	pid, err := l.checkRunning(ctx)
+	if err == nil {
+		err = l.cfg.signal(ctx, pid, sig)
+	}
+	return l.opError("signal", err)
+}
+
+// UpdateEnviron rewrites the exported environment of the init script, it
+// applies on the next start
 func (l *systemV) UpdateEnviron(environ map[string]string) (string, error) {
-	return "", nil
+	if ok, err := l.cfg.privileged(); !ok {
+		return "", l.opError("update", err)
+	}
+	updated := *l
+	updated.cfg.Environ = environ
+	p, err := updated.plan(OpUpdateEnviron, nil)
+	if err != nil {
+		return "", l.opError("update", err)
+	}
+	if err := l.cfg.apply(context.Background(), p); err != nil {
+		return "", l.opError("update", err)
+	}
+	return "updated", nil
 }
 
 // Stop the service
 func (l *systemV) Stop() (string, error) {
-	if ok, err := checkPrivileges(); !ok {
-		return "", err
+	return l.StopContext(context.Background())
+}
+
+// StopContext stops the service
+func (l *systemV) StopContext(ctx context.Context) (string, error) {
+	if ok, err := l.cfg.privileged(); !ok {
+		return "", l.opError("stop", err)
 	}
 	if !l.IsInstalled() {
-		return "", errNotInstalled
+		return "", l.opError("stop", ErrNotInstalled)
 	}
-	if err := exec.Command("service", l.name, "stop").Run(); err != nil {
-		return "", err
+	if err := l.cfg.run(ctx, "service", l.cfg.Name, "stop"); err != nil {
+		return "", l.opError("stop", err)
 	}
 	return stopped, nil
 }
 
 // Status - Get service status
 func (l *systemV) Status() (string, error) {
+	return l.StatusContext(context.Background())
+}
+
+// StatusContext gets service status
+func (l *systemV) StatusContext(ctx context.Context) (string, error) {
+	status, err := statusString(l.InspectContext(ctx))
+	return status, l.opError("status", err)
+}
+
+// Inspect returns structured service status
+func (l *systemV) Inspect() (StatusInfo, error) {
+	return l.InspectContext(context.Background())
+}
 
-	if ok, err := isRoot(); !ok {
-		return undefined, err
+// InspectContext returns structured service status
+func (l *systemV) InspectContext(ctx context.Context) (StatusInfo, error) {
+	info := StatusInfo{Backend: sysvBackend, PID: -1}
+	if ok, err := l.cfg.privileged(); !ok {
+		return info, l.opError("status", err)
 	}
 	if !l.IsInstalled() {
-		return undefined, errNotInstalled
+		info.State = StateNotInstalled
+		return info, nil
 	}
-	if pid, r := l.isRunning(); r {
-		return running + "(pid: " + strconv.Itoa(pid) + ")", nil
+	state, err := l.state(ctx)
+	if err != nil {
+		return info, l.opError("status", err)
 	}
-	return stopped, nil
+	info.State = state
+	if pid := readPidFile(l.pidFile()); state == StateRunning && pid > 0 {
+		info.PID = pid
+		info.StartedAt = processStartTime(pid)
+	}
+	return info, nil
+}
+
+// load parses the installed init script
+func (l *systemV) load() (ServiceConfig, error) {
+	content, err := ioutil.ReadFile(l.cfg.path(l.servicePath()))
+	if err != nil {
+		return l.cfg, err
+	}
+	cfg := l.cfg
+	cfg.Dependencies, cfg.Environ = nil, nil
+	cfg.Limits = Limits{}
+	cfg.RestartPolicy = RestartPolicy{}
+	cfg.StopSignal, cfg.StopTimeout, cfg.KillMode = 0, 0, ""
+	cfg.Hooks = Hooks{}
+	cfg.RestartPolicy.parseRespawnLoop(string(content))
+	cfg.Hooks.parseFunctions(hookScript, string(content))
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "# Description: "):
+			cfg.Description = strings.TrimPrefix(line, "# Description: ")
+		case strings.HasPrefix(line, "# Required-Start: "):
+			cfg.Dependencies = nil
+			for _, dep := range strings.Fields(strings.TrimPrefix(line, "# Required-Start: ")) {
+				if dep != "$network" && dep != "$named" {
+					cfg.Dependencies = append(cfg.Dependencies, dep)
+				}
+			}
+		case strings.HasPrefix(line, "export "):
+			cfg.Environ = parseQuotedEnv(line)
+		case strings.HasPrefix(line, "exec="):
+			if cmdline, ok := between(line, `exec="/bin/bash -c '`, ` >> $stdoutlog 2>> $stderrlog & ' "`); ok {
+				cfg.Cmd, cfg.Args = splitCommandLine(cmdline)
+			}
+		case strings.HasPrefix(line, "stdoutlog="):
+			cfg.LogFile = strings.Trim(strings.TrimPrefix(line, "stdoutlog="), `"`)
+		case strings.HasPrefix(line, "cd "):
+			cfg.WorkingDir = strings.TrimPrefix(line, "cd ")
+		case strings.HasPrefix(line, "start-stop-daemon "):
+			cfg.User, cfg.Group = parseChuid(line)
+		case strings.HasPrefix(line, "kill -"), strings.HasPrefix(line, "while kill -0 $pid "):
+			parseStopScript(&cfg, line)
+		case strings.HasPrefix(line, "ulimit "):
+			if f := strings.Fields(line); len(f) == 3 {
+				cfg.Limits.parse(limitUlimit, f[1], f[2])
+			}
+		}
+	}
+	if m, ok := parseMarker(content); ok {
+		cfg.Labels = m.Labels
+	}
+	return cfg, nil
 }
 
 var systemVConfig = `#! /bin/sh
+{{.Marker}}
 #
 #       /etc/rc.d/init.d/{{.Name}}
 #
@@ -218,8 +554,8 @@ var systemVConfig = `#! /bin/sh
 
 ### BEGIN INIT INFO
 # Provides: {{.Name}} 
-# Required-Start: $network $named
-# Required-Stop: $network $named
+# Required-Start: $network $named{{range .Dependencies}} {{.}}{{end}}
+# Required-Stop: $network $named{{range .Dependencies}} {{.}}{{end}}
 # Default-Start: 2 3 4 5
 # Default-Stop: 0 1 6
 # Short-Description: This service manages the {{.Description}}.
@@ -236,18 +572,24 @@ fi
 proc="{{.Name}}"
 pidfile="/var/run/$proc.pid"
 lockfile="/var/lock/subsys/$proc"
-stdoutlog="$logFile"
-stderrlog="$logFile"
-
+stdoutlog="{{.LogFile}}"
+stderrlog="{{.LogFile}}"
+{{if .EnVar}}{{.EnVar}}
+{{end}}
 exec="/bin/bash -c '{{.Cmd}} {{.Args}} >> $stdoutlog 2>> $stderrlog & ' "
 servname="{{.Description}}"
-
+{{if .RespawnLoop}}
+# respawn runs the service again when it exits
+respawn='{{.RespawnLoop}}'
+{{end}}
 
 [ -d $(dirname $lockfile) ] || mkdir -p $(dirname $lockfile)
 
 [ -e /etc/sysconfig/$proc ] && . /etc/sysconfig/$proc
 
-start() {
+{{if .HookFuncs}}{{.HookFuncs}}
+
+{{end}}start() {
 	cd {{.WorkingDir}}
     [ -x $(exec) ] || exit 5
 
@@ -263,10 +605,17 @@ start() {
     if ! [ -f $pidfile ]; then
         printf "Starting $servname:\t"
         echo "$(date)" >> $stdoutlog
-        $(exec)
-        echo $! > $pidfile
-        touch $lockfile
-        success
+{{if .Hooks.PreStart}}        pre_start || exit 1
+{{end}}{{if .Ulimits}}{{.Ulimits}}
+{{end}}{{if .User}}        if command -v start-stop-daemon > /dev/null 2>&1; then
+            start-stop-daemon --start --background --make-pidfile --pidfile $pidfile --chuid {{.Chuid}} --exec /bin/bash -- -c {{if .RespawnLoop}}"$respawn"{{else}}"exec {{.Cmd}} {{.Args}} >> $stdoutlog 2>> $stderrlog"{{end}}
+        else
+            su -s /bin/bash -c "{{.Launch}} & echo \$!" {{.User}} > $pidfile
+        fi
+{{else}}        /bin/bash -c "{{.Launch}} & echo \$!" > $pidfile
+{{end}}        touch $lockfile
+{{if .Hooks.PostStart}}        post_start
+{{end}}        command -v success > /dev/null 2>&1 && success
         echo
     else
         # failure
@@ -278,9 +627,16 @@ start() {
 
 stop() {
     echo -n $"Stopping $servname: "
-    killproc -p $pidfile $proc
-    retval=$?
-    echo
+{{if .Hooks.PreStop}}    pre_stop
+{{end}}{{if .Stop}}{{.Stop}}
+{{else}}    if command -v killproc > /dev/null 2>&1; then
+        killproc -p $pidfile $proc
+    else
+        kill $(cat $pidfile) && rm -f $pidfile
+    fi
+{{end}}    retval=$?
+{{if .Hooks.PostStop}}    post_stop
+{{end}}    echo
     [ $retval -eq 0 ] && rm -f $lockfile
     return $retval
 }
@@ -290,8 +646,19 @@ restart() {
     start
 }
 
+# rh_status exits as LSB status action: 0 running, 1 dead with pid file,
+# 3 stopped
 rh_status() {
-    status -p $pidfile $proc
+    if [ -f $pidfile ]; then
+        if kill -0 $(cat $pidfile) 2> /dev/null; then
+            echo "$proc is running"
+            return 0
+        fi
+        echo "$proc is dead but pid file exists"
+        return 1
+    fi
+    echo "$proc is stopped"
+    return 3
 }
 
 rh_status_q() {
@@ -313,8 +680,13 @@ case "$1" in
     status)
         rh_status
         ;;
+    reload)
+        rh_status_q || exit 7
+{{if .Hooks.Reload}}        $1
+{{else}}        kill -HUP $(cat $pidfile)
+{{end}}        ;;
     *)
-        echo $"Usage: $0 {start|stop|status|restart}"
+        echo $"Usage: $0 {start|stop|status|restart|reload}"
         exit 2
 esac
 
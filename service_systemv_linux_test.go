@@ -0,0 +1,55 @@
+package supervisor
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testBackend installs services of tests common to the platforms
+const testBackend = sysvBackend
+
+func TestLsbState(t *testing.T) {
+	tests := []struct {
+		code  int
+		state State
+	}{
+		{0, StateRunning},
+		{1, StateFailed},
+		{2, StateFailed},
+		{3, StateStopped},
+		{4, StateUnknown},
+		{127, StateUnknown},
+	}
+	for _, tt := range tests {
+		if state := lsbState(tt.code); state != tt.state {
+			t.Errorf("lsbState(%d) = %v, want %v", tt.code, state, tt.state)
+		}
+	}
+}
+
+func TestSystemVRoundTrip(t *testing.T) {
+	checkRoundTrip(t, ServiceConfig{
+		Backend:      sysvBackend,
+		Name:         "app",
+		Cmd:          "/usr/bin/app",
+		Args:         []string{"-v", "--port", "8080"},
+		Description:  "Test application",
+		WorkingDir:   "/opt/app",
+		LogFile:      "/var/log/app.log",
+		Dependencies: []string{"postgresql", "redis"},
+		Environ:      map[string]string{"MODE": "production", "GREETING": "hello world"},
+		Labels:       map[string]string{"team": "edge"},
+		Limits:       Limits{NOFILE: 4096},
+		RestartPolicy: RestartPolicy{
+			Mode:        RestartOnFailure,
+			Delay:       5 * time.Second,
+			MaxAttempts: 3,
+			Window:      time.Minute,
+		},
+		StopSignal:  syscall.SIGINT,
+		StopTimeout: 20 * time.Second,
+		KillMode:    KillGroup,
+		Hooks:       Hooks{PreStart: []string{"mkdir -p /run/app"}, Reload: []string{"kill -USR1 $(cat $pidfile)"}},
+	})
+}
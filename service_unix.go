@@ -3,47 +3,73 @@
 package supervisor
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"os/exec"
+	"strconv"
+	"syscall"
 )
 
-func run(command string, arguments ...string) error {
-	cmd := exec.Command(command, arguments...)
+// run runs command through configured Executor
+func (c *ServiceConfig) run(ctx context.Context, command string, arguments ...string) error {
+	_, err := c.output(ctx, command, arguments...)
+	return err
+}
 
-	// Connect pipe to read Stderr
-	stderr, err := cmd.StderrPipe()
+// output runs command through configured Executor and returns its stdout
+func (c *ServiceConfig) output(ctx context.Context, command string, arguments ...string) ([]byte, error) {
+	return c.execute(ctx, Command{Path: command, Args: arguments})
+}
 
-	if err != nil {
-		// Failed to connect pipe
-		return fmt.Errorf("%q failed to connect stderr pipe: %v", command, err)
+// execute runs cmd through configured Executor and returns its stdout
+func (c *ServiceConfig) execute(ctx context.Context, cmd Command) ([]byte, error) {
+	if c.offline() {
+		// commands would act on the host, not on RootDir
+		return nil, commandError(ErrNotSupported, nil, cmd.Path, cmd.Args...)
+	}
+	if c.escalating() {
+		var err error
+		if cmd, err = c.Escalate.command(cmd); err != nil {
+			return nil, commandError(err, nil, cmd.Path, cmd.Args...)
+		}
 	}
 
-	// Do not use cmd.Run()
-	if err := cmd.Start(); err != nil {
-		// Problem while copying stdin, stdout, or stderr
-		return fmt.Errorf("%q failed: %v", command, err)
+	stdout, stderr, err := c.executor().Execute(ctx, cmd)
+	if err != nil {
+		if ctxErr := contextError(ctx, cmd.Path); ctxErr != nil {
+			err = ctxErr
+		}
+		// Command didn't exit with a zero exit status.
+		return stdout, commandError(err, stderr, cmd.Path, cmd.Args...)
 	}
 
 	// Zero exit status
 	// Darwin: launchctl can fail with a zero exit status,
 	// so check for emtpy stderr
-	if command == "launchctl" {
-		slurp, _ := ioutil.ReadAll(stderr)
-		if len(slurp) > 0 {
-			return fmt.Errorf("%q failed with stderr: %s", command, slurp)
-		}
+	if cmd.Path == "launchctl" && len(stderr) > 0 {
+		return stdout, commandError(errors.New("failed with stderr"), stderr, cmd.Path, cmd.Args...)
 	}
 
-	if err := cmd.Wait(); err != nil {
-		// Command didn't exit with a zero exit status.
-		return fmt.Errorf("%q failed: %v", command, err)
-	}
+	return stdout, nil
+}
 
-	return nil
+// signal delivers sig to process pid through configured Executor, like
+// other commands it reaches remote hosts and is escalated
+func (c *ServiceConfig) signal(ctx context.Context, pid int, sig syscall.Signal) error {
+	if pid <= 0 {
+		return ErrNotRunning
+	}
+	return c.run(ctx, "kill", "-"+strconv.Itoa(int(sig)), strconv.Itoa(pid))
 }
 
-func runWithOutput(command string, arguments ...string) ([]byte, error) {
-	cmd := exec.Command(command, arguments...)
-	return cmd.CombinedOutput()
+// contextError converts expired or cancelled context into command error
+func contextError(ctx context.Context, command string) error {
+	switch err := ctx.Err(); {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.DeadlineExceeded):
+		return &TimeoutError{Command: command, Err: err}
+	default:
+		return fmt.Errorf("%q cancelled: %v", command, err)
+	}
 }
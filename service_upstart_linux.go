@@ -1,179 +1,595 @@
 package supervisor
 
 import (
+	"bytes"
+	"context"
+	"io/ioutil"
 	"os"
-	"os/exec"
-	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
+	"time"
 )
 
+const upstartBackend = "upstart"
+
 // upstart - standard record (struct) for linux upstart version of daemon package
 type upstart struct {
-	name         string
-	cmd          string
-	description  string
-	dependencies []string
-	workingDir   string
-	logFile      string
-	environ      map[string]string
+	cfg ServiceConfig
 }
 
 // Standard service path for systemV daemons
 func (u *upstart) servicePath() string {
-	return "/etc/init/" + u.name + ".conf"
+	return "/etc/init/" + u.cfg.Name + ".conf"
+}
+
+// overridePath is the override file of the job, its manual stanza keeps
+// the job from starting at boot
+func (u *upstart) overridePath() string {
+	return "/etc/init/" + u.cfg.Name + ".override"
+}
+
+func (u *upstart) serviceDir() (string, string) {
+	return "/etc/init", ".conf"
 }
 
 // Is a service installed
 func (u *upstart) IsInstalled() bool {
-	if _, err := os.Stat(u.servicePath()); err == nil {
+	if _, err := os.Stat(u.cfg.path(u.servicePath())); err == nil {
 		return true
 	}
 	return false
 }
 
 func (u *upstart) ServiceName() string {
-	return u.name + ".conf"
+	return u.cfg.Name + ".conf"
+}
+
+// opError annotates err with the operation and service
+func (u *upstart) opError(op string, err error) error {
+	return newOpError(op, u.cfg.Name, upstartBackend, err)
 }
 
 // Check service is running
-func (u *upstart) checkRunning() (int, error) {
-	output, err := exec.Command("status", u.name).Output()
-	if err == nil {
-		if matched, err := regexp.MatchString(u.name+" start/running", string(output)); err == nil && matched {
-			reg := regexp.MustCompile("process ([0-9]+)")
-			data := reg.FindStringSubmatch(string(output))
-			if len(data) > 1 {
-				return strconv.Atoi(data[1])
+func (u *upstart) checkRunning(ctx context.Context) (int, error) {
+	state, pid, err := u.state(ctx)
+	if err != nil {
+		return -1, err
+	}
+	if state != StateRunning {
+		return -1, ErrNotRunning
+	}
+	return pid, nil
+}
+
+// state runs initctl status in the C locale, see parseInitctlStatus
+func (u *upstart) state(ctx context.Context) (State, int, error) {
+	out, err := u.cfg.execute(ctx, Command{
+		Path: "initctl",
+		Args: []string{"status", u.cfg.Name},
+		Env:  []string{"LC_ALL=C"},
+	})
+	if err != nil {
+		return StateUnknown, -1, err
+	}
+	state, pid := parseInitctlStatus(out)
+	return state, pid, nil
+}
+
+// parseInitctlStatus parses state and main pid of the job from
+// "job goal/state, process pid" line of initctl status
+func parseInitctlStatus(out []byte) (State, int) {
+	line := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0]
+	state, pid := StateUnknown, -1
+	fields := strings.Fields(strings.Replace(line, ",", " ", -1))
+	for i, f := range fields {
+		switch {
+		case state == StateUnknown && (strings.HasPrefix(f, "start/") || strings.HasPrefix(f, "stop/")):
+			gs := strings.SplitN(f, "/", 2)
+			state = upstartState(gs[0], gs[1])
+		case f == "process" && i+1 < len(fields):
+			if p, err := strconv.Atoi(fields[i+1]); err == nil {
+				pid = p
 			}
-			return -1, nil
 		}
 	}
-	return -1, errNotRunning
+	return state, pid
+}
+
+// upstartState maps goal and state of the job to State
+func upstartState(goal, state string) State {
+	switch {
+	case goal == "start" && state == "running":
+		return StateRunning
+	case goal == "stop" && state == "waiting":
+		return StateStopped
+	}
+	// job is changing towards its goal
+	return StateActivating
 }
 
 // Install the service
 func (u *upstart) Install(args ...string) (string, error) {
-	if ok, err := checkPrivileges(); !ok {
+	return u.InstallContext(context.Background(), args...)
+}
+
+// InstallContext installs the service, ctx bounds creation of the service
+// account and file changes made with commands run through Escalate
+func (u *upstart) InstallContext(ctx context.Context, args ...string) (string, error) {
+	if ok, err := u.cfg.privileged(); !ok {
+		return "", u.opError("install", err)
+	}
+	p, err := u.plan(OpInstall, args)
+	if err != nil {
+		return "", u.opError("install", err)
+	}
+	if err := u.cfg.apply(ctx, p); err != nil {
+		return "", u.opError("install", err)
+	}
+	return installed, nil
+}
+
+// Remove the service
+func (u *upstart) Remove() (string, error) {
+	return u.RemoveContext(context.Background())
+}
+
+// RemoveContext removes the service, ctx bounds file changes made with
+// commands run through Escalate
+func (u *upstart) RemoveContext(ctx context.Context) (string, error) {
+	if ok, err := u.cfg.privileged(); !ok {
+		return "", u.opError("remove", err)
+	}
+	p, err := u.plan(OpRemove, nil)
+	if err != nil {
+		return "", u.opError("remove", err)
+	}
+	if err := u.cfg.apply(ctx, p); err != nil {
+		return "", u.opError("remove", err)
+	}
+	return removed, nil
+}
+
+// Plan returns side effects of op without applying them
+func (u *upstart) Plan(op Operation, args ...string) (*Plan, error) {
+	p, err := u.plan(op, args)
+	return p, u.opError(string(op), err)
+}
+
+func (u *upstart) plan(op Operation, args []string) (*Plan, error) {
+	p := u.cfg.newPlan(op, upstartBackend)
+	switch op {
+	case OpInstall:
+		if u.IsInstalled() {
+			return nil, ErrAlreadyInstalled
+		}
+		if err := u.cfg.planAccount(p, shadowTools); err != nil {
+			return nil, err
+		}
+		conf, err := u.render(args)
+		if err != nil {
+			return nil, err
+		}
+		p.writeFile(u.servicePath(), 0755, conf)
+	case OpRemove:
+		if !u.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		p.remove(u.servicePath(), false)
+		p.remove(u.overridePath(), true)
+	case OpEnable:
+		if !u.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		// the override may not exist
+		p.remove(u.overridePath(), true)
+	case OpDisable:
+		if !u.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		p.writeFile(u.overridePath(), 0644, []byte("manual\n"))
+	case OpUpdateEnviron:
+		if !u.IsInstalled() {
+			return nil, ErrNotInstalled
+		}
+		conf, err := u.render(nil)
+		if err != nil {
+			return nil, err
+		}
+		p.writeFile(u.servicePath(), 0755, conf)
+	case opReconcile:
+		// upstart watches /etc/init for changes
+		conf, err := u.render(args)
+		if err != nil {
+			return nil, err
+		}
+		p.writeFile(u.servicePath(), 0755, conf)
+	default:
+		return nil, ErrNotSupported
+	}
+	return p, nil
+}
+
+// Enable the job at boot
+func (u *upstart) Enable() (string, error) {
+	return u.EnableContext(context.Background())
+}
+
+// EnableContext removes the override file of the job, ctx bounds removal
+// made with commands run through Escalate
+func (u *upstart) EnableContext(ctx context.Context) (string, error) {
+	if err := u.switchBoot(ctx, OpEnable); err != nil {
 		return "", err
 	}
-	srvPath := u.servicePath()
+	return enabled, nil
+}
 
-	if u.IsInstalled() {
-		return "", errAlreadyInstalled
+// Disable the job at boot
+func (u *upstart) Disable() (string, error) {
+	return u.DisableContext(context.Background())
+}
+
+// DisableContext writes the manual stanza to the override file of the
+// job, ctx bounds writing made with commands run through Escalate
+func (u *upstart) DisableContext(ctx context.Context) (string, error) {
+	if err := u.switchBoot(ctx, OpDisable); err != nil {
+		return "", err
 	}
-	file, err := os.Create(srvPath)
+	return disabled, nil
+}
 
+// switchBoot applies OpEnable or OpDisable, upstart watches /etc/init
+// for changes
+func (u *upstart) switchBoot(ctx context.Context, op Operation) error {
+	if ok, err := u.cfg.privileged(); !ok {
+		return u.opError(string(op), err)
+	}
+	p, err := u.plan(op, nil)
 	if err != nil {
-		return "", err
+		return u.opError(string(op), err)
 	}
-	defer file.Close()
+	return u.opError(string(op), u.cfg.apply(ctx, p))
+}
 
+func (u *upstart) IsEnabled() (bool, error) {
+	return u.IsEnabledContext(context.Background())
+}
+
+// IsEnabledContext checks the override file of the job for the manual
+// stanza
+func (u *upstart) IsEnabledContext(ctx context.Context) (bool, error) {
+	if !u.IsInstalled() {
+		return false, u.opError("status", ErrNotInstalled)
+	}
+	content, err := ioutil.ReadFile(u.cfg.path(u.overridePath()))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, u.opError("status", err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == "manual" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// render returns job config content
+func (u *upstart) render(args []string) ([]byte, error) {
 	templ, err := template.New("upstatConfig").Parse(upstatConfig)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if err := u.cfg.systemdOnly(upstartBackend); err != nil {
+		return nil, err
+	}
+	if err := u.cfg.RestartPolicy.check(upstartBackend, false); err != nil {
+		return nil, err
+	}
+	// upstart signals the process group of the job
+	if err := u.cfg.fixedKillMode(upstartBackend, KillGroup); err != nil {
+		return nil, err
+	}
+	stop, _, err := u.cfg.stopping()
+	if err != nil {
+		return nil, err
+	}
+	if err := u.cfg.Hooks.check(upstartBackend, false, false); err != nil {
+		return nil, err
 	}
+	respawn, delay := u.cfg.RestartPolicy.upstart()
+	var kill string
+	if u.cfg.StopSignal != 0 {
+		kill = "kill signal " + stop.signal
+	}
+	var buf bytes.Buffer
 	if err := templ.Execute(
-		file,
+		&buf,
 		&struct {
 			Name, Description, Args, WorkingDir string
-			Cmd                                 string
+			Dependencies, Environ               []string
+			Cmd, LogFile, Marker                string
+			User, Group, Limits                 string
+			Respawn, Hooks, Kill                string
+			KillTimeout                         int
 		}{
-			Name:        u.name,
-			Cmd:         u.cmd,
-			Description: u.description,
-			WorkingDir:  u.workingDir,
-			Args:        strings.Join(args, " ")},
+			Name:         u.cfg.Name,
+			Dependencies: u.cfg.Dependencies,
+			Environ:      mapToSlice(u.cfg.Environ),
+			Cmd:          u.cfg.Cmd,
+			Description:  u.cfg.Description,
+			WorkingDir:   u.cfg.WorkingDir,
+			LogFile:      u.cfg.LogFile,
+			Marker:       u.cfg.marker(u.servicePath()).comment("# ", ""),
+			User:         u.cfg.User,
+			Group:        u.cfg.Group,
+			Limits:       u.cfg.Limits.upstart(),
+			Respawn:      respawn,
+			Hooks:        u.cfg.Hooks.upstart(delay),
+			Kill:         kill,
+			KillTimeout:  seconds(u.cfg.StopTimeout),
+			Args:         strings.Join(u.cfg.args(args), " ")},
 	); err != nil {
-		return "", err
+		return nil, err
 	}
+	return buf.Bytes(), nil
+}
 
-	if err := os.Chmod(srvPath, 0755); err != nil {
-		return "", err
+// UpdateEnviron rewrites env stanzas of the job, they apply on the next
+// start
+func (u *upstart) UpdateEnviron(env map[string]string) (string, error) {
+	if ok, err := u.cfg.privileged(); !ok {
+		return "", u.opError("update", err)
 	}
-	return installed, nil
+	updated := *u
+	updated.cfg.Environ = env
+	p, err := updated.plan(OpUpdateEnviron, nil)
+	if err != nil {
+		return "", u.opError("update", err)
+	}
+	if err := u.cfg.apply(context.Background(), p); err != nil {
+		return "", u.opError("update", err)
+	}
+	return "updated", nil
 }
 
-// Remove the service
-func (u *upstart) Remove() (string, error) {
-	if ok, err := checkPrivileges(); !ok {
-		return "", err
+func (u *upstart) Restart() (string, error) {
+	return u.RestartContext(context.Background())
+}
+
+// RestartContext restarts the service
+func (u *upstart) RestartContext(ctx context.Context) (string, error) {
+	u.StopContext(ctx)
+	return u.StartContext(ctx)
+}
+
+func (u *upstart) Reload() (string, error) {
+	return u.ReloadContext(context.Background())
+}
+
+// ReloadContext reloads the job, upstart sends SIGHUP to its main process
+func (u *upstart) ReloadContext(ctx context.Context) (string, error) {
+	if ok, err := u.cfg.privileged(); !ok {
+		return "", u.opError("reload", err)
 	}
 	if !u.IsInstalled() {
-		return "", errNotInstalled
+		return "", u.opError("reload", ErrNotInstalled)
 	}
-	if err := os.Remove(u.servicePath()); err != nil {
-		return "", err
+	if err := u.cfg.run(ctx, "initctl", "reload", u.cfg.Name); err != nil {
+		return "", u.opError("reload", err)
 	}
-	return removed, nil
+	return reloaded, nil
 }
 
-func (u *upstart) UpdateEnviron(env map[string]string) (string, error) {
-	return "", nil
+func (u *upstart) Signal(sig syscall.Signal) error {
+	return u.SignalContext(context.Background(), sig)
 }
 
-func (u *upstart) Restart() (string, error) {
-	u.Stop()
-	return u.Start()
+// SignalContext delivers sig to the main process of the job
+func (u *upstart) SignalContext(ctx context.Context, sig syscall.Signal) error {
+	pid, err := u.checkRunning(ctx)
+	if err == nil {
+		err = u.cfg.signal(ctx, pid, sig)
+	}
+	return u.opError("signal", err)
 }
 
 func (u *upstart) PID() (int, error) {
-	return u.checkRunning()
+	return u.checkRunning(context.Background())
 }
 
 // Start the service
 func (u *upstart) Start() (string, error) {
-	if ok, err := checkPrivileges(); !ok {
-		return "", err
+	return u.StartContext(context.Background())
+}
+
+// StartContext starts the service
+func (u *upstart) StartContext(ctx context.Context) (string, error) {
+	if ok, err := u.cfg.privileged(); !ok {
+		return "", u.opError("start", err)
 	}
 	if !u.IsInstalled() {
-		return "", errNotInstalled
+		return "", u.opError("start", ErrNotInstalled)
 	}
-	if err := exec.Command("start", u.name).Run(); err != nil {
-		return "", err
+	if err := u.cfg.run(ctx, "start", u.cfg.Name); err != nil {
+		return "", u.opError("start", err)
 	}
 	return started, nil
 }
 
 // Stop the service
 func (u *upstart) Stop() (string, error) {
-	if ok, err := checkPrivileges(); !ok {
-		return "", err
+	return u.StopContext(context.Background())
+}
+
+// StopContext stops the service
+func (u *upstart) StopContext(ctx context.Context) (string, error) {
+	if ok, err := u.cfg.privileged(); !ok {
+		return "", u.opError("stop", err)
 	}
 	if !u.IsInstalled() {
-		return "", errNotInstalled
+		return "", u.opError("stop", ErrNotInstalled)
 	}
-	if err := exec.Command("stop", u.name).Run(); err != nil {
-		return "", err
+	if err := u.cfg.run(ctx, "stop", u.cfg.Name); err != nil {
+		return "", u.opError("stop", err)
 	}
 	return stopped, nil
 }
 
 // Status - Get service status
 func (u *upstart) Status() (string, error) {
-	if ok, err := checkPrivileges(); !ok {
-		return "", err
+	return u.StatusContext(context.Background())
+}
+
+// StatusContext gets service status
+func (u *upstart) StatusContext(ctx context.Context) (string, error) {
+	status, err := statusString(u.InspectContext(ctx))
+	return status, u.opError("status", err)
+}
+
+// Inspect returns structured service status
+func (u *upstart) Inspect() (StatusInfo, error) {
+	return u.InspectContext(context.Background())
+}
+
+// InspectContext returns structured service status
+func (u *upstart) InspectContext(ctx context.Context) (StatusInfo, error) {
+	info := StatusInfo{Backend: upstartBackend, PID: -1}
+	if ok, err := u.cfg.privileged(); !ok {
+		return info, u.opError("status", err)
 	}
 	if !u.IsInstalled() {
-		return "Status could not defined", errNotInstalled
+		info.State = StateNotInstalled
+		return info, nil
 	}
-	pid, err := u.checkRunning()
+	state, pid, err := u.state(ctx)
 	if err != nil {
-		return "", err
+		return info, u.opError("status", err)
+	}
+	info.State = state
+	if pid > 0 {
+		info.PID = pid
+		info.StartedAt = processStartTime(pid)
+	}
+	return info, nil
+}
+
+// load parses the installed job config
+func (u *upstart) load() (ServiceConfig, error) {
+	content, err := ioutil.ReadFile(u.cfg.path(u.servicePath()))
+	if err != nil {
+		return u.cfg, err
+	}
+	cfg := u.cfg
+	cfg.Dependencies, cfg.Environ = nil, nil
+	cfg.Limits = Limits{}
+	cfg.RestartPolicy = RestartPolicy{Mode: RestartNever}
+	// upstart signals the process group, render accepts no other mode
+	cfg.StopSignal, cfg.StopTimeout, cfg.KillMode = 0, 0, KillGroup
+	cfg.Hooks = Hooks{}
+	// bare respawn is the default of the zero policy
+	limited := false
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "respawn" {
+			cfg.RestartPolicy.Mode = RestartAlways
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) < 2 {
+			continue
+		}
+		value := strings.TrimSpace(fields[1])
+		switch fields[0] {
+		case "description":
+			cfg.Description = strings.Trim(value, `"`)
+		case "start":
+			// start on runlevel [2345] and started dependency ...
+			for _, event := range strings.Split(value, " and ")[1:] {
+				cfg.Dependencies = append(cfg.Dependencies, strings.TrimPrefix(event, "started "))
+			}
+		case "env":
+			for k, v := range parseQuotedEnv(value) {
+				if cfg.Environ == nil {
+					cfg.Environ = make(map[string]string)
+				}
+				cfg.Environ[k] = v
+			}
+		case "chdir":
+			cfg.WorkingDir = value
+		case "setuid":
+			cfg.User = value
+		case "setgid":
+			cfg.Group = value
+		case "respawn":
+			if value == "limit unlimited" {
+				limited = true
+			}
+			if f := strings.Fields(value); len(f) == 3 && f[0] == "limit" {
+				limited = true
+				cfg.RestartPolicy.MaxAttempts, _ = strconv.Atoi(f[1])
+				if v, err := strconv.Atoi(f[2]); err == nil {
+					cfg.RestartPolicy.Window = time.Duration(v) * time.Second
+				}
+			}
+		case "normal":
+			if value == "exit 0" {
+				cfg.RestartPolicy.Mode = RestartOnFailure
+			}
+		case "post-stop":
+			if v, err := strconv.Atoi(strings.TrimPrefix(value, "exec sleep ")); err == nil {
+				cfg.RestartPolicy.Delay = time.Duration(v) * time.Second
+			}
+		case "kill":
+			if f := strings.Fields(value); len(f) == 2 && f[0] == "signal" {
+				cfg.StopSignal, _ = parseSignal(f[1])
+			} else if len(f) == 2 && f[0] == "timeout" {
+				if v, err := strconv.Atoi(f[1]); err == nil {
+					cfg.StopTimeout = time.Duration(v) * time.Second
+				}
+			}
+		case "limit":
+			if f := strings.SplitN(value, " ", 2); len(f) == 2 {
+				cfg.Limits.parse(limitUpstart, f[0], f[1])
+			}
+		case "exec":
+			if cmdline, ok := between(value, "/bin/sh -c '", " 2>&1 '"); ok {
+				// jobs written before the shell exec'd the command lack exec
+				cmdline, cfg.LogFile = splitLogRedirect(strings.TrimPrefix(cmdline, "exec "), " >> ")
+				cfg.Cmd, cfg.Args = splitCommandLine(cmdline)
+			}
+		}
 	}
-	return "(pid: " + strconv.Itoa(pid) + ")", nil
+	if delay := cfg.Hooks.parseUpstart(string(content)); delay > 0 {
+		cfg.RestartPolicy.Delay = time.Duration(delay) * time.Second
+	}
+	if !limited && cfg.RestartPolicy.Mode == RestartAlways {
+		cfg.RestartPolicy = RestartPolicy{}
+	}
+	if m, ok := parseMarker(content); ok {
+		cfg.Labels = m.Labels
+	}
+	return cfg, nil
 }
 
-var upstatConfig = `# {{.Name}} {{.Description}}
+var upstatConfig = `{{.Marker}}
+# {{.Name}} {{.Description}}
 
 description     "{{.Description}}"
 
-start on runlevel [2345]
+start on runlevel [2345]{{range .Dependencies}} and started {{.}}{{end}}
 stop on runlevel [016]
-
-respawn
-#kill timeout 5
-chdir {{.WorkingDir}}
-exec /bin/sh -c '{{.Cmd}} {{.Args}} >> {{.logFile}} 2>&1 '
+{{range .Environ}}env "{{.}}"
+{{end}}
+{{if .Respawn}}{{.Respawn}}
+{{end}}{{if .Hooks}}{{.Hooks}}
+{{end}}{{if .Limits}}{{.Limits}}
+{{end}}{{if .Kill}}{{.Kill}}
+{{end}}{{if .KillTimeout}}kill timeout {{.KillTimeout}}
+{{else}}#kill timeout 5
+{{end}}chdir {{.WorkingDir}}
+{{if .User}}setuid {{.User}}
+{{end}}{{if .Group}}setgid {{.Group}}
+{{end}}exec /bin/sh -c 'exec {{.Cmd}} {{.Args}} >> {{.LogFile}} 2>&1 '
 `
@@ -0,0 +1,77 @@
+package supervisor
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseInitctlStatus(t *testing.T) {
+	tests := []struct {
+		name  string
+		out   string
+		state State
+		pid   int
+	}{
+		{"running", "isaax-agent start/running, process 1234\n", StateRunning, 1234},
+		{"stopped", "isaax-agent stop/waiting\n", StateStopped, -1},
+		{"pre-start", "isaax-agent start/pre-start, process 812\n\tpre-start process 812\n", StateActivating, 812},
+		{"stopping", "isaax-agent stop/killed, process 1234\n", StateActivating, 1234},
+		{"instance", "isaax-agent (main) start/running, process 77\n", StateRunning, 77},
+		{"empty", "", StateUnknown, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, pid := parseInitctlStatus([]byte(tt.out))
+			if state != tt.state || pid != tt.pid {
+				t.Errorf("parseInitctlStatus(%q) = %v, %d, want %v, %d", tt.out, state, pid, tt.state, tt.pid)
+			}
+		})
+	}
+}
+
+// renderUpstart renders the job config of cfg
+func renderUpstart(t *testing.T, cfg ServiceConfig) string {
+	job, err := (&upstart{cfg: cfg}).render(nil)
+	if err != nil {
+		t.Fatalf("render() failed: %v", err)
+	}
+	return string(job)
+}
+
+func TestUpstartExec(t *testing.T) {
+	job := renderUpstart(t, ServiceConfig{Name: "app", Cmd: "/usr/bin/app", Args: []string{"-v"}, WorkingDir: "/opt/app", LogFile: "/var/log/app.log"})
+	// the main process of the job, signalled by reload and stop, is the
+	// command itself
+	want := "exec /bin/sh -c 'exec /usr/bin/app -v >> /var/log/app.log 2>&1 '\n"
+	if !strings.Contains(job, want) {
+		t.Errorf("job lacks %q:\n%s", want, job)
+	}
+}
+
+func TestUpstartRoundTrip(t *testing.T) {
+	checkRoundTrip(t, ServiceConfig{
+		Backend:      upstartBackend,
+		Name:         "app",
+		Cmd:          "/usr/bin/app",
+		Args:         []string{"-v", "--port", "8080"},
+		Description:  "Test application",
+		WorkingDir:   "/opt/app",
+		LogFile:      "/var/log/app.log",
+		Dependencies: []string{"postgresql", "redis"},
+		Environ:      map[string]string{"MODE": "production", "GREETING": "hello world"},
+		Labels:       map[string]string{"team": "edge"},
+		Limits:       Limits{NOFILE: 4096},
+		RestartPolicy: RestartPolicy{
+			Mode:        RestartAlways,
+			Delay:       5 * time.Second,
+			MaxAttempts: 3,
+			Window:      time.Minute,
+		},
+		StopSignal:  syscall.SIGINT,
+		StopTimeout: 20 * time.Second,
+		KillMode:    KillGroup,
+		Hooks:       Hooks{PreStart: []string{"mkdir -p /run/app"}, PostStop: []string{"rm -rf /run/app"}},
+	})
+}
@@ -0,0 +1,72 @@
+package supervisor
+
+import (
+	"strconv"
+	"time"
+)
+
+// State is the state of supervised service
+type State int
+
+const (
+	StateUnknown State = iota
+	StateRunning
+	StateStopped
+	StateFailed
+	StateActivating
+	StateNotInstalled
+)
+
+var stateNames = [...]string{
+	StateUnknown:      "unknown",
+	StateRunning:      "running",
+	StateStopped:      "stopped",
+	StateFailed:       "failed",
+	StateActivating:   "activating",
+	StateNotInstalled: "not-installed",
+}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return stateNames[StateUnknown]
+	}
+	return stateNames[s]
+}
+
+// StatusInfo describes service state as reported by the backend
+type StatusInfo struct {
+	// Backend is the init system name, e.g. systemd or procd
+	Backend string
+	State   State
+	// PID of the main process, -1 if not running
+	PID int
+	// StartedAt is the start time of the main process, zero if unknown
+	StartedAt time.Time
+	// ExitCode is the last exit code of the main process, zero if unknown
+	ExitCode int
+}
+
+// String returns status in the format of Service.Status
+func (i StatusInfo) String() string {
+	switch i.State {
+	case StateRunning:
+		if i.PID > 0 {
+			return running + "(pid: " + strconv.Itoa(i.PID) + ")"
+		}
+		return running
+	case StateNotInstalled:
+		return undefined
+	}
+	return i.State.String()
+}
+
+// statusString derives Status result from StatusInfo
+func statusString(info StatusInfo, err error) (string, error) {
+	if err != nil {
+		return undefined, err
+	}
+	if info.State == StateNotInstalled {
+		return undefined, ErrNotInstalled
+	}
+	return info.String(), nil
+}
@@ -0,0 +1,189 @@
+package supervisor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// KillMode selects processes of the service receiving StopSignal
+type KillMode string
+
+const (
+	// KillProcess signals the main process only
+	KillProcess KillMode = "process"
+	// KillGroup signals every process of the service, its process group
+	// or on systemd its control group
+	KillGroup KillMode = "group"
+)
+
+// defaultStopTimeout is StopTimeout of init scripts left zero
+const defaultStopTimeout = 10 * time.Second
+
+// signalNames are names of signals usable to stop the service
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:  "HUP",
+	syscall.SIGINT:  "INT",
+	syscall.SIGQUIT: "QUIT",
+	syscall.SIGKILL: "KILL",
+	syscall.SIGUSR1: "USR1",
+	syscall.SIGUSR2: "USR2",
+	syscall.SIGTERM: "TERM",
+}
+
+// signalName returns name of sig without SIG prefix
+func signalName(sig syscall.Signal) (string, error) {
+	if name, ok := signalNames[sig]; ok {
+		return name, nil
+	}
+	return "", fmt.Errorf("%w: signal %v", ErrNotSupported, sig)
+}
+
+// parseSignal returns signal named name with or without SIG prefix
+func parseSignal(name string) (syscall.Signal, bool) {
+	name = strings.TrimPrefix(name, "SIG")
+	for sig, n := range signalNames {
+		if n == name {
+			return sig, true
+		}
+	}
+	return 0, false
+}
+
+// stopping describes how the service is stopped
+type stopping struct {
+	signal  string
+	timeout time.Duration
+	mode    KillMode
+}
+
+// stopping returns StopSignal, StopTimeout and KillMode with init script
+// defaults, false when none of them is set
+func (c *ServiceConfig) stopping() (stopping, bool, error) {
+	set := c.StopSignal != 0 || c.StopTimeout != 0 || c.KillMode != ""
+	s := stopping{signal: "TERM", timeout: c.StopTimeout, mode: c.KillMode}
+	if c.StopSignal != 0 {
+		name, err := signalName(c.StopSignal)
+		if err != nil {
+			return s, set, err
+		}
+		s.signal = name
+	}
+	if s.timeout == 0 {
+		s.timeout = defaultStopTimeout
+	}
+	switch s.mode {
+	case "":
+		s.mode = KillProcess
+	case KillProcess, KillGroup:
+	default:
+		return s, set, fmt.Errorf("unknown kill mode %q", s.mode)
+	}
+	return s, set, nil
+}
+
+// fixedStopSignal rejects StopSignal other than SIGTERM on backends
+// always sending it
+func (c *ServiceConfig) fixedStopSignal(backend string) error {
+	if c.StopSignal != 0 && c.StopSignal != syscall.SIGTERM {
+		return fmt.Errorf("%w: stop signal %v on %s", ErrNotSupported, c.StopSignal, backend)
+	}
+	return nil
+}
+
+// fixedKillMode rejects KillMode other than mode of backend
+func (c *ServiceConfig) fixedKillMode(backend string, mode KillMode) error {
+	if c.KillMode != "" && c.KillMode != mode {
+		return fmt.Errorf("%w: kill mode %s on %s", ErrNotSupported, c.KillMode, backend)
+	}
+	return nil
+}
+
+// setsid returns command prefix starting the service in its own session
+// so its process group can be signalled
+func (s stopping) setsid(loop bool) string {
+	if s.mode == KillGroup || loop {
+		return "setsid "
+	}
+	return ""
+}
+
+// ignoredInBackground reports whether the stop signal is SIGINT or
+// SIGQUIT, which shells ignore in commands started in the background
+// without job control. A trap cannot catch a signal ignored on entry.
+func (s stopping) ignoredInBackground() bool {
+	return s.signal == "INT" || s.signal == "QUIT"
+}
+
+// resetIgnored returns command restoring the default disposition of the
+// stop signal when it is started in the background by bash, dash and
+// busybox sh cannot reset it
+func (s stopping) resetIgnored(command string) string {
+	if s.ignoredInBackground() {
+		return "( trap - INT QUIT; exec " + command + " )"
+	}
+	return command
+}
+
+// script returns shell lines, each prefixed with indent, sending signal
+// to the process of pidFile, waiting for timeout and killing it then.
+// The respawn loop is killed with its process group. The exit status of
+// the lines is zero if the process stopped.
+func (s stopping) script(indent, pidFile string, loop bool) string {
+	target, kill := "$pid", "$pid"
+	if s.mode == KillGroup {
+		target, kill = "-$pid", "-$pid"
+	} else if loop {
+		kill = "-$pid"
+	}
+	lines := []string{
+		"pid=$(cat " + pidFile + ")",
+		"kill -" + s.signal + " " + target,
+		"waited=0",
+		"while kill -0 $pid 2> /dev/null && [ $waited -lt " + strconv.Itoa(seconds(s.timeout)) + " ]; do",
+		"    sleep 1",
+		"    waited=$((waited + 1))",
+		"done",
+		"if kill -0 $pid 2> /dev/null; then",
+		"    kill -KILL " + kill,
+		"    sleep 1",
+		"fi",
+		"! kill -0 $pid 2> /dev/null",
+	}
+	for i, line := range lines {
+		lines[i] = indent + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// trap returns the trap of the respawn loop forwarding the stop signal to
+// the service unless the group is signalled
+func (s stopping) trap() string {
+	forward := `kill -` + s.signal + ` \$child 2> /dev/null; `
+	if s.mode == KillGroup {
+		forward = ""
+	}
+	return `trap "` + forward + `wait \$child; exit 0" ` + s.signal
+}
+
+// parseStopScript parses lines returned by stopping.script into cfg
+func parseStopScript(cfg *ServiceConfig, line string) {
+	line = strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(line, "kill -") && strings.HasSuffix(line, "$pid") && !strings.HasPrefix(line, "kill -KILL "):
+		f := strings.Fields(line)
+		if sig, ok := parseSignal(strings.TrimPrefix(f[1], "-")); ok {
+			cfg.StopSignal = sig
+		}
+		cfg.KillMode = KillProcess
+		if f[2] == "-$pid" {
+			cfg.KillMode = KillGroup
+		}
+	case strings.HasPrefix(line, "while kill -0 $pid 2> /dev/null && [ $waited -lt "):
+		if v, err := strconv.Atoi(strings.Fields(line)[10]); err == nil {
+			cfg.StopTimeout = time.Duration(v) * time.Second
+		}
+	}
+}
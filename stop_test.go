@@ -0,0 +1,126 @@
+package supervisor
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStopping(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  ServiceConfig
+		want stopping
+		set  bool
+		ok   bool
+	}{
+		{"defaults", ServiceConfig{}, stopping{"TERM", defaultStopTimeout, KillProcess}, false, true},
+		{"signal", ServiceConfig{StopSignal: syscall.SIGINT}, stopping{"INT", defaultStopTimeout, KillProcess}, true, true},
+		{"timeout", ServiceConfig{StopTimeout: 30 * time.Second}, stopping{"TERM", 30 * time.Second, KillProcess}, true, true},
+		{"group", ServiceConfig{KillMode: KillGroup}, stopping{"TERM", defaultStopTimeout, KillGroup}, true, true},
+		{"unknown signal", ServiceConfig{StopSignal: syscall.SIGWINCH}, stopping{}, true, false},
+		{"unknown mode", ServiceConfig{KillMode: "cgroup"}, stopping{}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, set, err := tt.cfg.stopping()
+			if (err == nil) != tt.ok || set != tt.set || (tt.ok && got != tt.want) {
+				t.Errorf("stopping() = %+v, %v, %v, want %+v, %v", got, set, err, tt.want, tt.set)
+			}
+		})
+	}
+}
+
+func TestStopScript(t *testing.T) {
+	tests := []struct {
+		name string
+		stop stopping
+		loop bool
+		// signal and kill are the lines sending the stop signal and
+		// SIGKILL
+		signal, kill string
+	}{
+		{"process", stopping{"TERM", 10 * time.Second, KillProcess}, false, "kill -TERM $pid", "kill -KILL $pid"},
+		{"group", stopping{"INT", 10 * time.Second, KillGroup}, false, "kill -INT -$pid", "kill -KILL -$pid"},
+		// the respawn loop forwards the signal, SIGKILL takes its group
+		{"loop", stopping{"TERM", 10 * time.Second, KillProcess}, true, "kill -TERM $pid", "kill -KILL -$pid"},
+		{"group loop", stopping{"TERM", 10 * time.Second, KillGroup}, true, "kill -TERM -$pid", "kill -KILL -$pid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := tt.stop.script("  ", `"$pid_file"`, tt.loop)
+			lines := strings.Split(script, "\n")
+			for _, line := range lines {
+				if !strings.HasPrefix(line, "  ") {
+					t.Errorf("line %q is not indented", line)
+				}
+			}
+			for _, want := range []string{`  pid=$(cat "$pid_file")`, "  " + tt.signal, "      " + tt.kill, "  ! kill -0 $pid 2> /dev/null"} {
+				if !strings.Contains(script+"\n", want+"\n") {
+					t.Errorf("script lacks %q:\n%s", want, script)
+				}
+			}
+
+			var cfg ServiceConfig
+			for _, line := range lines {
+				parseStopScript(&cfg, line)
+			}
+			sig, _ := parseSignal(tt.stop.signal)
+			if cfg.StopSignal != sig || cfg.StopTimeout != tt.stop.timeout || cfg.KillMode != tt.stop.mode {
+				t.Errorf("parsed %v, %v, %v from:\n%s", cfg.StopSignal, cfg.StopTimeout, cfg.KillMode, script)
+			}
+		})
+	}
+}
+
+func TestStopFragments(t *testing.T) {
+	tests := []struct {
+		stop         stopping
+		loop         bool
+		setsid       string
+		trap         string
+		resetIgnored string
+		inBackground bool
+	}{
+		{stopping{signal: "TERM", mode: KillProcess}, false, "", `trap "kill -TERM \$child 2> /dev/null; wait \$child; exit 0" TERM`, "app", false},
+		{stopping{signal: "TERM", mode: KillProcess}, true, "setsid ", `trap "kill -TERM \$child 2> /dev/null; wait \$child; exit 0" TERM`, "app", false},
+		// the group is signalled, the loop does not forward
+		{stopping{signal: "TERM", mode: KillGroup}, false, "setsid ", `trap "wait \$child; exit 0" TERM`, "app", false},
+		{stopping{signal: "INT", mode: KillProcess}, false, "", `trap "kill -INT \$child 2> /dev/null; wait \$child; exit 0" INT`, "( trap - INT QUIT; exec app )", true},
+		{stopping{signal: "QUIT", mode: KillGroup}, true, "setsid ", `trap "wait \$child; exit 0" QUIT`, "( trap - INT QUIT; exec app )", true},
+	}
+	for _, tt := range tests {
+		if got := tt.stop.setsid(tt.loop); got != tt.setsid {
+			t.Errorf("setsid(%v) of %+v = %q, want %q", tt.loop, tt.stop, got, tt.setsid)
+		}
+		if got := tt.stop.trap(); got != tt.trap {
+			t.Errorf("trap() of %+v = %q, want %q", tt.stop, got, tt.trap)
+		}
+		if got := tt.stop.resetIgnored("app"); got != tt.resetIgnored {
+			t.Errorf("resetIgnored() of %+v = %q, want %q", tt.stop, got, tt.resetIgnored)
+		}
+		if got := tt.stop.ignoredInBackground(); got != tt.inBackground {
+			t.Errorf("ignoredInBackground() of %+v = %v", tt.stop, got)
+		}
+	}
+}
+
+func TestFixedStop(t *testing.T) {
+	tests := []struct {
+		cfg            ServiceConfig
+		signal, killed bool
+	}{
+		{ServiceConfig{}, true, true},
+		{ServiceConfig{StopSignal: syscall.SIGTERM, KillMode: KillProcess}, true, true},
+		{ServiceConfig{StopSignal: syscall.SIGINT, KillMode: KillGroup}, false, false},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.fixedStopSignal("test"); (err == nil) != tt.signal {
+			t.Errorf("fixedStopSignal() of %v returned %v", tt.cfg.StopSignal, err)
+		}
+		if err := tt.cfg.fixedKillMode("test", KillProcess); (err == nil) != tt.killed {
+			t.Errorf("fixedKillMode() of %q returned %v", tt.cfg.KillMode, err)
+		}
+	}
+}